@@ -5,27 +5,70 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/abaddouh/poll-streamer/internal/auth"
+	"github.com/abaddouh/poll-streamer/internal/metrics"
+	"github.com/abaddouh/poll-streamer/internal/publisher"
+	"github.com/abaddouh/poll-streamer/internal/recorder"
+	"github.com/abaddouh/poll-streamer/internal/registry"
+	"github.com/abaddouh/poll-streamer/internal/segstore"
 	"github.com/abaddouh/poll-streamer/internal/server"
+	"github.com/abaddouh/poll-streamer/internal/source"
 	"github.com/abaddouh/poll-streamer/internal/streamer"
 	"github.com/abaddouh/poll-streamer/internal/watcher"
 )
 
 func main() {
 	imagePath := flag.String("path", os.Getenv("IMAGE_PATH"), "Path to the directory containing images")
+	enqueueLatest := flag.Bool("enqueue-latest", false, "On startup, enqueue the newest image already in each stream directory")
 	outputPath := flag.String("output", os.Getenv("OUTPUT_PATH"), "Path to output the HLS stream files")
 	frameRate := flag.Int("fps", 30, "Frames per second for the output video")
 	resolution := flag.String("resolution", "640x480", "Resolution of the output video")
 	bitrate := flag.String("bitrate", "500k", "Bitrate of the output video")
+	segmentFormat := flag.String("segment-format", streamer.SegmentFormatMPEGTS, "Default segment format, mpegts or fmp4")
+	dash := flag.Bool("dash", false, "Also publish streams as MPEG-DASH by default")
+	rtmp := flag.Bool("rtmp", false, "Also push streams over RTMP by default")
+	rtmpURL := flag.String("rtmp-url", streamer.DefaultRTMPURL, "Default RTMP push target, {stream_id} being replaced with the stream ID")
+	renditions := flag.String("renditions", "", "Default adaptive bitrate ladder, e.g. 1280x720@2500k,640x360@800k (single rendition if empty)")
+	maxFrameRate := flag.Int("max-fps", 60, "Maximum frames per second a stream may request")
+	maxResolution := flag.String("max-resolution", "1920x1080", "Maximum resolution a stream may request")
+	maxBitrate := flag.String("max-bitrate", "8M", "Maximum bitrate a stream may request")
+	dvrWindow := flag.Duration("dvr-window", 0, "Default DVR window kept in stream playlists, e.g. 2h (0 keeps only the live edge)")
+	playlistType := flag.String("playlist-type", "", "Default playlist type, empty for a sliding live playlist or event")
+	maxDVRWindow := flag.Duration("max-dvr-window", 6*time.Hour, "Maximum DVR window a stream may request")
+	minFreeDisk := flag.Int64("min-free-disk-mb", 1024, "Prune retained DVR segments when free space on the output volume drops below this many megabytes (0 disables)")
+	memoryStore := flag.Int64("memory-store-mb", 0, "Keep the HLS output of streams in memory, bounded to this many megabytes, instead of on disk (0 disables)")
+	publish := flag.Bool("publish", false, "Publish streams to the S3 bucket by default (requires -s3-bucket)")
+	s3Endpoint := flag.String("s3-endpoint", os.Getenv("S3_ENDPOINT"), "Base URL of the S3-compatible API streams are published to, e.g. https://s3.eu-west-1.amazonaws.com")
+	s3Bucket := flag.String("s3-bucket", os.Getenv("S3_BUCKET"), "Bucket streams are published to (publishing disabled if empty)")
+	s3Region := flag.String("s3-region", publisher.DefaultRegion, "Region requests to the S3 API are signed for")
+	s3Prefix := flag.String("s3-prefix", "", "Key prefix of published streams")
+	s3PublicURL := flag.String("s3-public-url", "", "Base URL viewers reach the bucket at, e.g. a CDN (default: <s3-endpoint>/<s3-bucket>)")
 	port := flag.Int("port", 8080, "Port to serve the HLS stream")
+	publicURL := flag.String("public-url", os.Getenv("PUBLIC_URL"), "Base URL clients reach the server at, e.g. https://streams.example.com (default: the scheme and host of each request)")
 	workerCount := flag.Int("workers", runtime.NumCPU(), "Number of worker goroutines")
 	placeholderImg := flag.String("placeholder", "./placeholder.jpg", "Path to the placeholder image")
+	ttl := flag.Duration("ttl", 0, "Default maximum lifetime of a stream, e.g. 24h (0 disables)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "Default time after which a stream with no frames and no viewers is stopped, e.g. 15m (0 disables)")
+	expiryWebhook := flag.String("expiry-webhook", os.Getenv("EXPIRY_WEBHOOK_URL"), "URL expiry events are POSTed to as JSON when a stream expires")
+	apiKeysPath := flag.String("api-keys", os.Getenv("API_KEYS_FILE"), "Path to a file of API keys, one \"<key> <scope>[,<scope>...]\" per line")
+	tokenSecret := flag.String("token-secret", os.Getenv("TOKEN_SECRET"), "Secret used to sign and verify bearer tokens")
+	insecureNoAuth := flag.Bool("insecure-no-auth", false, "Serve the API without authentication when no API keys or token secret are configured")
+	recordingsPath := flag.String("recordings", os.Getenv("RECORDINGS_PATH"), "Path to a directory archiving the frames of recorded streams (recording disabled if empty)")
+	record := flag.Bool("record", false, "Record every stream by default (requires -recordings)")
+	recordingRetention := flag.Duration("recording-retention", 0, "Delete recorded frames older than this, e.g. 168h (0 keeps them forever)")
+	recordingMaxSize := flag.Int64("recording-max-mb", 0, "Delete the oldest frames of a recording once it outgrows this many megabytes (0 disables)")
+	sourceAllow := flag.String("source-allow", os.Getenv("SOURCE_ALLOW"), "Comma-separated CIDR ranges or addresses HTTP sources may poll on top of public addresses, e.g. 10.20.0.0/16")
+	registryPath := flag.String("registry", os.Getenv("REGISTRY_PATH"), "Path to a JSON file persisting streams across restarts (in-memory if empty)")
 
 	flag.Parse()
 
@@ -51,15 +94,155 @@ func main() {
 		log.Fatal("Please provide the path to the image directory using the -path flag or IMAGE_PATH environment variable")
 	}
 
-	w, err := watcher.New(*imagePath)
+	w, err := watcher.New(*imagePath, *enqueueLatest)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// Sources feeding every stream, on top of the sources streams are
+	// bound to through the API.
+	sources := []source.Source{source.NewWatcher(w)}
+
+	limits := streamer.DefaultLimits()
+	limits.MaxFrameRate = *maxFrameRate
+	limits.MaxWidth, limits.MaxHeight, err = streamer.ParseResolution(*maxResolution)
+	if err != nil {
+		log.Fatalf("Invalid -max-resolution: %v", err)
+	}
+	limits.MaxDVRWindow = int(maxDVRWindow.Seconds())
+	limits.MaxBitrate, err = streamer.ParseBitrate(*maxBitrate)
+	if err != nil {
+		log.Fatalf("Invalid -max-bitrate: %v", err)
+	}
+
+	ladder, err := streamer.ParseRenditions(*renditions)
+	if err != nil {
+		log.Fatalf("Invalid -renditions: %v", err)
+	}
+
+	defaults := streamer.EncodingParams{
+		FrameRate:     *frameRate,
+		Resolution:    *resolution,
+		Bitrate:       *bitrate,
+		Renditions:    ladder,
+		SegmentFormat: *segmentFormat,
+		DASH:          *dash,
+		RTMP:          *rtmp,
+		RTMPURL:       *rtmpURL,
+		Record:        *record,
+		DVRWindow:     int(dvrWindow.Seconds()),
+		PlaylistType:  *playlistType,
+		Publish:       *publish,
+	}
+
+	var rec *recorder.Recorder
+	if *recordingsPath != "" {
+		rec, err = recorder.New(*recordingsPath, recorder.Retention{
+			MaxAge:   *recordingRetention,
+			MaxBytes: *recordingMaxSize << 20,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var pub *publisher.Publisher
+	if *s3Bucket != "" {
+		pub, err = publisher.New(publisher.Config{
+			Endpoint:  *s3Endpoint,
+			Bucket:    *s3Bucket,
+			Region:    *s3Region,
+			AccessKey: firstEnv("S3_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID"),
+			SecretKey: firstEnv("S3_SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY"),
+			Prefix:    *s3Prefix,
+			PublicURL: *s3PublicURL,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var segments *segstore.Store
+	var ingestURL string
+	if *memoryStore > 0 {
+		segments = segstore.New(*memoryStore << 20)
+		ingestURL = fmt.Sprintf("http://127.0.0.1:%d/internal/segments", *port)
+	}
 
 	// Capture the streamer instance
-	streamerInstance := streamer.New(*outputPath, *frameRate, *resolution, *bitrate, *placeholderImg)
+	streamerInstance := streamer.New(streamer.Config{
+		OutputPath:     *outputPath,
+		PlaceholderImg: *placeholderImg,
+		Defaults:       defaults,
+		Limits:         limits,
+		Recorder:       rec,
+		Segments:       segments,
+		IngestURL:      ingestURL,
+		Publisher:      pub,
+	})
+	if _, err := streamerInstance.ResolveParams(streamer.EncodingParams{}); err != nil {
+		log.Fatalf("Default encoding parameters exceed the configured limits: %v", err)
+	}
+
+	jobQueue := make(chan watcher.WatcherJob, 100)
+	metrics.RegisterJobQueue(
+		func() int { return len(jobQueue) },
+		func() int { return cap(jobQueue) },
+	)
+
+	var streams registry.Registry = registry.NewMemoryRegistry()
+	if *registryPath != "" {
+		streams, err = registry.NewFileRegistry(*registryPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	authenticator, err := loadAuth(*apiKeysPath, os.Getenv("API_KEYS"), *tokenSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !authenticator.Enabled() {
+		if !*insecureNoAuth {
+			log.Fatal("No API keys or token secret configured; set -api-keys, API_KEYS or -token-secret, or pass -insecure-no-auth to serve the API unauthenticated")
+		}
+		log.Println("Warning: no API keys or token secret configured, the API is unauthenticated")
+	}
+
+	if *publicURL != "" {
+		if u, err := url.Parse(*publicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("Invalid -public-url %q, expected an http:// or https:// URL", *publicURL)
+		}
+	}
+
+	allowedNetworks, err := source.ParseNetworks(*sourceAllow)
+	if err != nil {
+		log.Fatalf("Invalid -source-allow: %v", err)
+	}
+
+	var onExpire func(server.ExpiryEvent)
+	if *expiryWebhook != "" {
+		onExpire, err = server.ExpiryWebhook(*expiryWebhook)
+		if err != nil {
+			log.Fatalf("Invalid -expiry-webhook: %v", err)
+		}
+	}
 
-	srv := server.New(*port, streamerInstance)
+	srv := server.New(server.Config{
+		Port:        *port,
+		ImagePath:   *imagePath,
+		TTL:         *ttl,
+		IdleTimeout: *idleTimeout,
+		Auth:        authenticator,
+		Recorder:    rec,
+		Segments:    segments,
+		PublicURL:   *publicURL,
+		OnExpire:    onExpire,
+		SourceAllow: allowedNetworks,
+	}, streamerInstance, jobQueue, streams)
+
+	if err := srv.Restore(); err != nil {
+		log.Fatal(err)
+	}
 
 	// Create a context that we can cancel
 	ctx, cancel := context.WithCancel(context.Background())
@@ -69,19 +252,32 @@ func main() {
 	var wg sync.WaitGroup
 
 	// Start the worker pool
-	jobQueue := make(chan watcher.WatcherJob, 100)
 	for i := 0; i < *workerCount; i++ {
 		wg.Add(1)
 		go worker(ctx, &wg, streamerInstance, srv, jobQueue)
 	}
 
-	// Start the watcher
+	// Start the sources
+	for _, src := range sources {
+		src.Start(ctx, jobQueue)
+	}
+
+	// Start the reaper
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		w.Start(ctx, jobQueue)
+		srv.RunReaper(ctx)
 	}()
 
+	// Start the disk pruner
+	if *minFreeDisk > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			streamerInstance.RunDiskPruner(ctx, uint64(*minFreeDisk)<<20)
+		}()
+	}
+
 	// Start the server
 	wg.Add(1)
 	go func() {
@@ -99,15 +295,28 @@ func main() {
 	log.Println("Shutting down...")
 	cancel()
 
+	// Wait for the sources to stop
+	for _, src := range sources {
+		src.Stop()
+	}
+
 	// Shutdown streamer processes
 	streamerInstance.Shutdown()
 
 	// Wait for all goroutines to finish
 	wg.Wait()
 
-	// Clean up the stream folder
-	if err := os.RemoveAll(*outputPath); err != nil {
-		log.Printf("Error cleaning up stream folder: %v", err)
+	// Abort exports still being rendered
+	if rec != nil {
+		rec.Close()
+	}
+
+	// Clean up the stream folder, unless the streams are expected to come
+	// back after a restart
+	if !streams.Persistent() {
+		if err := os.RemoveAll(*outputPath); err != nil {
+			log.Printf("Error cleaning up stream folder: %v", err)
+		}
 	}
 
 	log.Println("Shutdown complete")
@@ -132,6 +341,44 @@ func ensureDir(path string) error {
 	return nil
 }
 
+// loadAuth builds the Authenticator from the API keys in keysPath and
+// envKeys and the token secret.
+func loadAuth(keysPath, envKeys, secret string) (*auth.Authenticator, error) {
+	keys, err := auth.ParseKeys(strings.NewReader(envKeys))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing API_KEYS: %v", err)
+	}
+
+	if keysPath != "" {
+		f, err := os.Open(keysPath)
+		if err != nil {
+			return nil, fmt.Errorf("error opening API keys file: %v", err)
+		}
+		defer f.Close()
+
+		fileKeys, err := auth.ParseKeys(f)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing API keys file %s: %v", keysPath, err)
+		}
+		for key, scopes := range fileKeys {
+			keys[key] = scopes
+		}
+	}
+
+	return auth.New(keys, secret), nil
+}
+
+// firstEnv returns the first of the named environment variables that is
+// set.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
 func worker(ctx context.Context, wg *sync.WaitGroup, s *streamer.Streamer, srv *server.Server, jobs <-chan watcher.WatcherJob) {
 	defer wg.Done()
 	for {
@@ -145,12 +392,22 @@ func worker(ctx context.Context, wg *sync.WaitGroup, s *streamer.Streamer, srv *
 				return
 			}
 			log.Printf("Worker received job: StreamID=%s, FilePath=%s", job.StreamID, job.FilePath)
+			start := time.Now()
 			if _, exists := srv.GetStreamPath(job.StreamID); exists {
 				log.Printf("StreamID %s exists. Processing image.", job.StreamID)
-				s.ProcessImage(job.FilePath, job.StreamID)
+				var err error
+				if job.Data != nil {
+					err = s.ProcessImageData(job.StreamID, job.Data)
+				} else {
+					err = s.ProcessImage(job.StreamID, job.FilePath)
+				}
+				if err != nil {
+					log.Printf("Error processing image for stream %s: %v", job.StreamID, err)
+				}
 			} else {
 				log.Printf("Stream %s not found. Skipping job for FilePath=%s", job.StreamID, job.FilePath)
 			}
+			metrics.WorkerBusySeconds.Add(time.Since(start).Seconds())
 		}
 	}
 }
@@ -80,7 +80,8 @@ go run cmd/server/main.go \
   -bitrate 1000k \
   -port 8080 \
   -workers 4 \
-  -placeholder ./placeholder.jpg &
+  -placeholder ./placeholder.jpg \
+  -insecure-no-auth &
 SERVER_PID=$!
 echo "Poll Streamer Server started with PID: $SERVER_PID"
 
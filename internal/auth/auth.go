@@ -0,0 +1,212 @@
+// Package auth authenticates API requests with static API keys and
+// HMAC-signed bearer tokens, and checks the scopes they grant.
+package auth
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Scope is a permission granted to an API key or token.
+type Scope string
+
+const (
+	// ScopeAdmin grants every permission, including shutting the server
+	// down and replacing the placeholder image.
+	ScopeAdmin Scope = "admin"
+	// ScopeProducer allows creating and removing streams and pushing frames.
+	ScopeProducer Scope = "producer"
+	// ScopeViewer allows reading playlists and segments.
+	ScopeViewer Scope = "viewer"
+)
+
+var (
+	// ErrNoCredentials is returned when a request carries no credentials.
+	ErrNoCredentials = errors.New("missing credentials")
+	// ErrInvalidCredentials is returned for unknown keys and for tokens that
+	// are malformed, forged or expired.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// ParseScope validates a scope name.
+func ParseScope(name string) (Scope, error) {
+	switch scope := Scope(strings.TrimSpace(name)); scope {
+	case ScopeAdmin, ScopeProducer, ScopeViewer:
+		return scope, nil
+	default:
+		return "", fmt.Errorf("unknown scope %q", name)
+	}
+}
+
+// Principal is the identity behind an authenticated request.
+type Principal struct {
+	Subject string  `json:"sub"`
+	Scopes  []Scope `json:"scopes"`
+}
+
+// Has reports whether the principal was granted scope. Admins are granted
+// every scope.
+func (p Principal) Has(scope Scope) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator verifies API keys and bearer tokens.
+type Authenticator struct {
+	// keys maps the SHA-256 of each API key to its principal, so lookups do
+	// not compare secrets byte by byte.
+	keys   map[[sha256.Size]byte]Principal
+	secret []byte
+}
+
+// New returns an Authenticator accepting the given API keys and, if secret
+// is not empty, bearer tokens signed with it.
+func New(keys map[string][]Scope, secret string) *Authenticator {
+	a := &Authenticator{
+		keys:   make(map[[sha256.Size]byte]Principal, len(keys)),
+		secret: []byte(secret),
+	}
+	for key, scopes := range keys {
+		a.keys[sha256.Sum256([]byte(key))] = Principal{Subject: "api-key", Scopes: scopes}
+	}
+	return a
+}
+
+// Enabled reports whether any credentials are configured. A disabled
+// Authenticator should not be used to guard requests.
+func (a *Authenticator) Enabled() bool {
+	return len(a.keys) > 0 || len(a.secret) > 0
+}
+
+// ParseKeys reads API keys, one per line, in the form "<key> <scope>[,<scope>...]".
+// Blank lines and lines starting with # are ignored, and ";" may be used
+// instead of a line break so keys can be passed in a single environment
+// variable.
+func ParseKeys(r io.Reader) (map[string][]Scope, error) {
+	keys := make(map[string][]Scope)
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		for _, entry := range strings.Split(scanner.Text(), ";") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" || strings.HasPrefix(entry, "#") {
+				continue
+			}
+			fields := strings.Fields(entry)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: expected \"<key> <scopes>\"", lineNo)
+			}
+			var scopes []Scope
+			for _, name := range strings.Split(fields[1], ",") {
+				scope, err := ParseScope(name)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", lineNo, err)
+				}
+				scopes = append(scopes, scope)
+			}
+			keys[fields[0]] = scopes
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// claims is the signed payload of a bearer token.
+type claims struct {
+	Principal
+	ExpiresAt int64 `json:"exp"`
+}
+
+// IssueToken returns a bearer token for subject granting scopes until ttl
+// has elapsed. Tokens have the form "<payload>.<signature>", both base64url
+// encoded, where the signature is an HMAC-SHA256 of the payload.
+func (a *Authenticator) IssueToken(subject string, scopes []Scope, ttl time.Duration) (string, time.Time, error) {
+	if len(a.secret) == 0 {
+		return "", time.Time{}, errors.New("no token secret configured")
+	}
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	payload, err := json.Marshal(claims{
+		Principal: Principal{Subject: subject, Scopes: scopes},
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error encoding token: %v", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + a.sign(encoded), expiresAt, nil
+}
+
+// sign returns the base64url encoded HMAC of data.
+func (a *Authenticator) sign(data string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// constantTimeEqual compares two signatures without leaking where they
+// differ.
+func constantTimeEqual(a, b string) bool {
+	return hmac.Equal([]byte(a), []byte(b))
+}
+
+// verifyToken checks the signature and expiry of a bearer token.
+func (a *Authenticator) verifyToken(token string) (Principal, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || len(a.secret) == 0 {
+		return Principal{}, ErrInvalidCredentials
+	}
+	if !constantTimeEqual(signature, a.sign(encoded)) {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Principal{}, ErrInvalidCredentials
+	}
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return Principal{}, ErrInvalidCredentials
+	}
+	if time.Now().Unix() >= c.ExpiresAt {
+		return Principal{}, ErrInvalidCredentials
+	}
+	return c.Principal, nil
+}
+
+// Authenticate identifies the caller of r from an "Authorization: Bearer"
+// header, which may hold an API key or a signed token, or from an
+// X-API-Key header.
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, error) {
+	credential := r.Header.Get("X-API-Key")
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, value, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return Principal{}, ErrInvalidCredentials
+		}
+		credential = strings.TrimSpace(value)
+	}
+	if credential == "" {
+		return Principal{}, ErrNoCredentials
+	}
+
+	if principal, ok := a.keys[sha256.Sum256([]byte(credential))]; ok {
+		return principal, nil
+	}
+	return a.verifyToken(credential)
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKeys(t *testing.T) {
+	input := `# comment
+admin-key admin
+
+viewer-key viewer; producer-key producer,viewer
+`
+	keys, err := ParseKeys(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseKeys: %v", err)
+	}
+	want := map[string][]Scope{
+		"admin-key":    {ScopeAdmin},
+		"viewer-key":   {ScopeViewer},
+		"producer-key": {ScopeProducer, ScopeViewer},
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("ParseKeys = %v, want %v", keys, want)
+	}
+
+	for _, input := range []string{"key", "key viewer extra", "key owner", "key viewer,"} {
+		if _, err := ParseKeys(strings.NewReader(input)); err == nil {
+			t.Errorf("ParseKeys(%q) succeeded, want an error", input)
+		}
+	}
+}
+
+func TestHas(t *testing.T) {
+	tests := []struct {
+		scopes []Scope
+		scope  Scope
+		want   bool
+	}{
+		{[]Scope{ScopeViewer}, ScopeViewer, true},
+		{[]Scope{ScopeViewer}, ScopeProducer, false},
+		{[]Scope{ScopeViewer}, ScopeAdmin, false},
+		{[]Scope{ScopeProducer}, ScopeViewer, false},
+		{[]Scope{ScopeProducer, ScopeViewer}, ScopeViewer, true},
+		{[]Scope{ScopeAdmin}, ScopeProducer, true},
+		{[]Scope{ScopeAdmin}, ScopeViewer, true},
+		{nil, ScopeViewer, false},
+	}
+	for _, tt := range tests {
+		if got := (Principal{Scopes: tt.scopes}).Has(tt.scope); got != tt.want {
+			t.Errorf("%v.Has(%s) = %v, want %v", tt.scopes, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	a := New(map[string][]Scope{"secret-key": {ScopeProducer}}, "")
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		wantErr error
+	}{
+		{"api key header", map[string]string{"X-API-Key": "secret-key"}, nil},
+		{"bearer", map[string]string{"Authorization": "Bearer secret-key"}, nil},
+		{"lowercase scheme", map[string]string{"Authorization": "bearer secret-key"}, nil},
+		{"no credentials", nil, ErrNoCredentials},
+		{"empty bearer", map[string]string{"Authorization": "Bearer "}, ErrNoCredentials},
+		{"unknown key", map[string]string{"X-API-Key": "other-key"}, ErrInvalidCredentials},
+		{"basic scheme", map[string]string{"Authorization": "Basic c2VjcmV0LWtleQ=="}, ErrInvalidCredentials},
+		{"authorization overrides api key", map[string]string{"X-API-Key": "secret-key", "Authorization": "Bearer other-key"}, ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/streams", nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			principal, err := a.Authenticate(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if principal.Subject != "api-key" || !principal.Has(ScopeProducer) || principal.Has(ScopeAdmin) {
+				t.Errorf("Authenticate = %+v, want a producer api-key principal", principal)
+			}
+		})
+	}
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	a := New(nil, "token-secret")
+	token, expiresAt, err := a.IssueToken("dashboard", []Scope{ScopeViewer}, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+	if d := time.Until(expiresAt); d <= 59*time.Minute || d > time.Hour {
+		t.Errorf("IssueToken expiry is %v away, want about an hour", d)
+	}
+
+	r := httptest.NewRequest("GET", "/stream/a/playlist.m3u8", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	principal, err := a.Authenticate(r)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if principal.Subject != "dashboard" {
+		t.Errorf("Subject = %q, want dashboard", principal.Subject)
+	}
+	if !principal.Has(ScopeViewer) {
+		t.Error("token principal lacks the viewer scope it was issued")
+	}
+	if principal.Has(ScopeProducer) || principal.Has(ScopeAdmin) {
+		t.Errorf("viewer token grants more than it was issued: %v", principal.Scopes)
+	}
+
+	// An API key and a token are told apart by lookup, so both work side
+	// by side.
+	both := New(map[string][]Scope{"secret-key": {ScopeAdmin}}, "token-secret")
+	if _, err := both.Authenticate(r); err != nil {
+		t.Errorf("Authenticate with keys configured: %v", err)
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	a := New(nil, "token-secret")
+	valid, _, err := a.IssueToken("dashboard", []Scope{ScopeProducer}, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+	expired, _, err := a.IssueToken("dashboard", []Scope{ScopeProducer}, -time.Minute)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+
+	payload, signature, _ := strings.Cut(valid, ".")
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatalf("decoding payload: %v", err)
+	}
+	var c claims
+	if err := json.Unmarshal(decoded, &c); err != nil {
+		t.Fatalf("decoding claims: %v", err)
+	}
+	c.Scopes = []Scope{ScopeAdmin}
+	escalated, _ := json.Marshal(c)
+	tamperedPayload := base64.RawURLEncoding.EncodeToString(escalated) + "." + signature
+
+	// Change the first character of the signature: unlike the last one,
+	// all of its bits are significant.
+	flipped := "A"
+	if signature[0] == 'A' {
+		flipped = "B"
+	}
+	tamperedSignature := payload + "." + flipped + signature[1:]
+
+	other, _, err := New(nil, "other-secret").IssueToken("dashboard", []Scope{ScopeProducer}, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		auth    *Authenticator
+		token   string
+		wantErr error
+	}{
+		{"valid", a, valid, nil},
+		{"expired", a, expired, ErrInvalidCredentials},
+		{"tampered payload", a, tamperedPayload, ErrInvalidCredentials},
+		{"tampered signature", a, tamperedSignature, ErrInvalidCredentials},
+		{"missing signature", a, payload, ErrInvalidCredentials},
+		{"empty signature", a, payload + ".", ErrInvalidCredentials},
+		{"wrong secret", a, other, ErrInvalidCredentials},
+		{"no secret configured", New(nil, ""), valid, ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := tt.auth.verifyToken(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("verifyToken error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(principal.Scopes, []Scope{ScopeProducer}) {
+				t.Errorf("verifyToken scopes = %v, want [producer]", principal.Scopes)
+			}
+		})
+	}
+}
+
+func TestIssueTokenWithoutSecret(t *testing.T) {
+	a := New(map[string][]Scope{"secret-key": {ScopeAdmin}}, "")
+	if _, _, err := a.IssueToken("dashboard", []Scope{ScopeViewer}, time.Hour); err == nil {
+		t.Error("IssueToken succeeded without a secret")
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	if New(nil, "").Enabled() {
+		t.Error("Authenticator without keys or secret is enabled")
+	}
+	if !New(map[string][]Scope{"k": {ScopeViewer}}, "").Enabled() {
+		t.Error("Authenticator with keys is disabled")
+	}
+	if !New(nil, "secret").Enabled() {
+		t.Error("Authenticator with a secret is disabled")
+	}
+}
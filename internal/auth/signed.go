@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrExpired is returned for stream tokens whose expiry has passed.
+var ErrExpired = errors.New("token expired")
+
+// SignStream returns a token granting read access to the playlists and
+// segments of a stream until expiresAt. Tokens have the form
+// "<unix expiry>.<signature>", where the signature is an HMAC-SHA256 over
+// the stream ID and the expiry.
+func (a *Authenticator) SignStream(streamID string, expiresAt time.Time) (string, error) {
+	if len(a.secret) == 0 {
+		return "", errors.New("no token secret configured")
+	}
+	exp := strconv.FormatInt(expiresAt.Unix(), 10)
+	return exp + "." + a.sign("stream\n"+streamID+"\n"+exp), nil
+}
+
+// VerifyStream checks that token was issued by SignStream for streamID and
+// has not expired.
+func (a *Authenticator) VerifyStream(streamID, token string) error {
+	exp, signature, ok := strings.Cut(token, ".")
+	if !ok || len(a.secret) == 0 {
+		return ErrInvalidCredentials
+	}
+	expiresAt, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrInvalidCredentials
+	}
+	if !constantTimeEqual(signature, a.sign("stream\n"+streamID+"\n"+exp)) {
+		return ErrInvalidCredentials
+	}
+	if time.Now().Unix() >= expiresAt {
+		return ErrExpired
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyStream(t *testing.T) {
+	a := New(nil, "token-secret")
+	valid, err := a.SignStream("stream-a", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SignStream: %v", err)
+	}
+	expired, err := a.SignStream("stream-a", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("SignStream: %v", err)
+	}
+	other, err := New(nil, "other-secret").SignStream("stream-a", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SignStream: %v", err)
+	}
+
+	// Moving the expiry forward must invalidate the signature.
+	exp, signature, _ := strings.Cut(valid, ".")
+	extended := "9" + exp + "." + signature
+
+	tests := []struct {
+		name     string
+		auth     *Authenticator
+		streamID string
+		token    string
+		wantErr  error
+	}{
+		{"valid", a, "stream-a", valid, nil},
+		{"wrong stream", a, "stream-b", valid, ErrInvalidCredentials},
+		{"stream ID prefix", a, "stream-", valid, ErrInvalidCredentials},
+		{"expired", a, "stream-a", expired, ErrExpired},
+		{"expired for another stream", a, "stream-b", expired, ErrInvalidCredentials},
+		{"extended expiry", a, "stream-a", extended, ErrInvalidCredentials},
+		{"wrong secret", a, "stream-a", other, ErrInvalidCredentials},
+		{"missing signature", a, "stream-a", exp, ErrInvalidCredentials},
+		{"non-numeric expiry", a, "stream-a", "soon." + signature, ErrInvalidCredentials},
+		{"empty", a, "stream-a", "", ErrInvalidCredentials},
+		{"no secret configured", New(nil, ""), "stream-a", valid, ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.auth.VerifyStream(tt.streamID, tt.token); !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyStream error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStreamTokenIsNotBearerToken(t *testing.T) {
+	a := New(nil, "token-secret")
+	token, err := a.SignStream("stream-a", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SignStream: %v", err)
+	}
+	if _, err := a.verifyToken(token); err == nil {
+		t.Error("stream token was accepted as a bearer token")
+	}
+
+	bearer, _, err := a.IssueToken("stream-a", []Scope{ScopeViewer}, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+	if err := a.VerifyStream("stream-a", bearer); err == nil {
+		t.Error("bearer token was accepted as a stream token")
+	}
+}
+
+func TestSignStreamWithoutSecret(t *testing.T) {
+	if _, err := New(nil, "").SignStream("stream-a", time.Now().Add(time.Hour)); err == nil {
+		t.Error("SignStream succeeded without a secret")
+	}
+}
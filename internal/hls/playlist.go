@@ -0,0 +1,101 @@
+// Package hls reads and rewrites HLS playlists.
+package hls
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// URIAttribute matches the URI attribute of playlist tags such as
+// EXT-X-MAP or EXT-X-MEDIA.
+var URIAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+
+// TrimSegments removes the leading media segments of a media playlist for
+// which keep, given the segment's URI as listed, returns false. Trimming
+// stops at the first segment kept. The media and discontinuity sequence
+// numbers are advanced to match, and the EXT-X-MAP of a removed segment is
+// carried over to the first one kept, which still needs its init segment.
+func TrimSegments(playlist []byte, keep func(uri string) bool) []byte {
+	lines := strings.Split(strings.TrimRight(string(playlist), "\n"), "\n")
+
+	var header, body, group []string
+	var carriedMap string
+	dropped, droppedDiscontinuities := 0, 0
+	inSegments, trimming := false, true
+	for _, line := range lines {
+		text := strings.TrimSpace(line)
+		if !inSegments {
+			if strings.HasPrefix(text, "#EXTINF") || text == "#EXT-X-DISCONTINUITY" ||
+				strings.HasPrefix(text, "#EXT-X-PROGRAM-DATE-TIME") {
+				inSegments = true
+			} else {
+				header = append(header, line)
+				continue
+			}
+		}
+
+		group = append(group, line)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if trimming && !keep(text) {
+			dropped++
+			for _, tag := range group {
+				switch {
+				case strings.TrimSpace(tag) == "#EXT-X-DISCONTINUITY":
+					droppedDiscontinuities++
+				case strings.HasPrefix(strings.TrimSpace(tag), "#EXT-X-MAP"):
+					carriedMap = tag
+				}
+			}
+		} else {
+			if trimming && carriedMap != "" && !hasMap(group) {
+				body = append(body, carriedMap)
+			}
+			trimming = false
+			body = append(body, group...)
+		}
+		group = nil
+	}
+	body = append(body, group...)
+	if dropped == 0 {
+		return playlist
+	}
+
+	var out []string
+	hasMediaSequence, hasDiscontinuitySequence := false, false
+	for _, line := range header {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			n, _ := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+			line = fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d", n+dropped)
+			hasMediaSequence = true
+		case strings.HasPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:"):
+			n, _ := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:"))
+			line = fmt.Sprintf("#EXT-X-DISCONTINUITY-SEQUENCE:%d", n+droppedDiscontinuities)
+			hasDiscontinuitySequence = true
+		}
+		out = append(out, line)
+	}
+	// Both sequence numbers default to 0 when their tag is missing.
+	if !hasMediaSequence {
+		out = append(out, fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d", dropped))
+	}
+	if !hasDiscontinuitySequence && droppedDiscontinuities > 0 {
+		out = append(out, fmt.Sprintf("#EXT-X-DISCONTINUITY-SEQUENCE:%d", droppedDiscontinuities))
+	}
+	out = append(out, body...)
+	return []byte(strings.Join(out, "\n") + "\n")
+}
+
+// hasMap reports whether the tags of a segment include an EXT-X-MAP.
+func hasMap(group []string) bool {
+	for _, tag := range group {
+		if strings.HasPrefix(strings.TrimSpace(tag), "#EXT-X-MAP") {
+			return true
+		}
+	}
+	return false
+}
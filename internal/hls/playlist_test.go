@@ -0,0 +1,257 @@
+package hls
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTrimSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		playlist string
+		drop     []string
+		want     string
+	}{
+		{
+			name: "nothing to drop",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:3
+#EXTINF:2.0,
+segment3.ts
+`,
+			drop: []string{"segment9.ts"},
+			want: `#EXTM3U
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:3
+#EXTINF:2.0,
+segment3.ts
+`,
+		},
+		{
+			name: "media sequence",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:3
+#EXTINF:2.0,
+segment3.ts
+#EXTINF:2.0,
+segment4.ts
+#EXTINF:2.0,
+segment5.ts
+`,
+			drop: []string{"segment3.ts", "segment4.ts"},
+			want: `#EXTM3U
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:5
+#EXTINF:2.0,
+segment5.ts
+`,
+		},
+		{
+			name: "missing media sequence",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:2
+#EXTINF:2.0,
+segment0.ts
+#EXTINF:2.0,
+segment1.ts
+`,
+			drop: []string{"segment0.ts"},
+			want: `#EXTM3U
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:1
+#EXTINF:2.0,
+segment1.ts
+`,
+		},
+		{
+			name: "only the head is trimmed",
+			playlist: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:0
+#EXTINF:2.0,
+segment0.ts
+#EXTINF:2.0,
+segment1.ts
+#EXTINF:2.0,
+segment2.ts
+`,
+			drop: []string{"segment0.ts", "segment2.ts"},
+			want: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:1
+#EXTINF:2.0,
+segment1.ts
+#EXTINF:2.0,
+segment2.ts
+`,
+		},
+		{
+			name: "discontinuities",
+			playlist: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:10
+#EXTINF:2.0,
+segment10.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:2.0,
+segment11.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:2.0,
+segment12.ts
+`,
+			drop: []string{"segment10.ts", "segment11.ts"},
+			want: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:12
+#EXT-X-DISCONTINUITY-SEQUENCE:1
+#EXT-X-DISCONTINUITY
+#EXTINF:2.0,
+segment12.ts
+`,
+		},
+		{
+			name: "discontinuity sequence",
+			playlist: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:10
+#EXT-X-DISCONTINUITY-SEQUENCE:4
+#EXT-X-DISCONTINUITY
+#EXTINF:2.0,
+segment10.ts
+#EXTINF:2.0,
+segment11.ts
+`,
+			drop: []string{"segment10.ts"},
+			want: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:11
+#EXT-X-DISCONTINUITY-SEQUENCE:5
+#EXTINF:2.0,
+segment11.ts
+`,
+		},
+		{
+			name: "header map",
+			playlist: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:2.0,
+segment0.m4s
+#EXTINF:2.0,
+segment1.m4s
+`,
+			drop: []string{"segment0.m4s"},
+			want: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:1
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:2.0,
+segment1.m4s
+`,
+		},
+		{
+			name: "map carried over",
+			playlist: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-MAP:URI="init0.mp4"
+#EXTINF:2.0,
+segment0.m4s
+#EXT-X-DISCONTINUITY
+#EXT-X-MAP:URI="init1.mp4"
+#EXTINF:2.0,
+segment1.m4s
+#EXTINF:2.0,
+segment2.m4s
+`,
+			drop: []string{"segment0.m4s", "segment1.m4s"},
+			want: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:2
+#EXT-X-MAP:URI="init0.mp4"
+#EXT-X-DISCONTINUITY-SEQUENCE:1
+#EXT-X-MAP:URI="init1.mp4"
+#EXTINF:2.0,
+segment2.m4s
+`,
+		},
+		{
+			name: "map not carried over a newer one",
+			playlist: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:0
+#EXTINF:2.0,
+segment0.m4s
+#EXT-X-DISCONTINUITY
+#EXT-X-MAP:URI="init1.mp4"
+#EXTINF:2.0,
+segment1.m4s
+#EXT-X-DISCONTINUITY
+#EXT-X-MAP:URI="init2.mp4"
+#EXTINF:2.0,
+segment2.m4s
+`,
+			drop: []string{"segment0.m4s", "segment1.m4s"},
+			want: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:2
+#EXT-X-DISCONTINUITY-SEQUENCE:1
+#EXT-X-DISCONTINUITY
+#EXT-X-MAP:URI="init2.mp4"
+#EXTINF:2.0,
+segment2.m4s
+`,
+		},
+		{
+			name: "program date time",
+			playlist: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T00:00:00.000Z
+#EXTINF:2.0,
+segment0.ts
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T00:00:02.000Z
+#EXTINF:2.0,
+segment1.ts
+`,
+			drop: []string{"segment0.ts"},
+			want: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:1
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T00:00:02.000Z
+#EXTINF:2.0,
+segment1.ts
+`,
+		},
+		{
+			name: "every segment dropped",
+			playlist: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:0
+#EXTINF:2.0,
+segment0.ts
+#EXT-X-ENDLIST
+`,
+			drop: []string{"segment0.ts"},
+			want: `#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:1
+#EXT-X-ENDLIST
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep := func(uri string) bool {
+				for _, name := range tt.drop {
+					if uri == name {
+						return false
+					}
+				}
+				return true
+			}
+			got := string(TrimSegments([]byte(tt.playlist), keep))
+			if got != tt.want {
+				t.Errorf("TrimSegments =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURIAttribute(t *testing.T) {
+	line := `#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",URI="audio/playlist.m3u8",NAME="English"`
+	matches := URIAttribute.FindAllStringSubmatch(line, -1)
+	if len(matches) != 1 || matches[0][1] != "audio/playlist.m3u8" {
+		t.Errorf("URIAttribute matches %q, want audio/playlist.m3u8", matches)
+	}
+	if URIAttribute.MatchString(strings.Replace(line, "URI=", "NAME2=", 1)) {
+		t.Error("URIAttribute matched a line without a URI attribute")
+	}
+}
@@ -0,0 +1,153 @@
+// Package metrics defines the Prometheus metrics exported by the poll
+// streamer and the handler serving them.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "poll_streamer"
+
+var (
+	ActiveStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_streams",
+		Help:      "Number of streams currently managed by the streamer.",
+	})
+
+	JobsEnqueued = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_enqueued_total",
+		Help:      "Jobs placed on the job queue, by source.",
+	}, []string{"source"})
+
+	JobsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_dropped_total",
+		Help:      "Jobs dropped because the job queue was full, by source.",
+	}, []string{"source"})
+
+	WorkerBusySeconds = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "worker_busy_seconds_total",
+		Help:      "Total time workers spent processing jobs.",
+	})
+
+	WatcherEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "watcher_events_total",
+		Help:      "Filesystem events seen by the watcher, by operation.",
+	}, []string{"op"})
+
+	ImagesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "images_processed_total",
+		Help:      "Images accepted as the held frame of a stream.",
+	}, []string{"stream_id"})
+
+	FIFOWriteSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "fifo_write_seconds",
+		Help:      "Latency of writing a single frame into an encoder FIFO.",
+		Buckets:   []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2},
+	})
+
+	FFmpegRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ffmpeg_restarts_total",
+		Help:      "FFmpeg restarts performed by the supervisor, by stream.",
+	}, []string{"stream_id"})
+
+	SegmentsProduced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hls_segments_total",
+		Help:      "HLS segments opened for writing by FFmpeg, by stream.",
+	}, []string{"stream_id"})
+
+	LastSegmentTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "hls_last_segment_timestamp_seconds",
+		Help:      "Unix time at which FFmpeg last started a segment, by stream.",
+	}, []string{"stream_id"})
+
+	StreamsExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "streams_expired_total",
+		Help:      "Streams stopped by the reaper, by reason.",
+	}, []string{"reason"})
+
+	MJPEGViewers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "mjpeg_viewers",
+		Help:      "Viewers currently connected to MJPEG streams.",
+	})
+
+	MJPEGFramesDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mjpeg_frames_dropped_total",
+		Help:      "Frames skipped for MJPEG viewers that could not keep up.",
+	})
+
+	SegmentStoreBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "segment_store_bytes",
+		Help:      "Bytes of playlists and segments held in the in-memory segment store.",
+	})
+
+	SegmentStoreEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "segment_store_evictions_total",
+		Help:      "Segments evicted from the in-memory segment store, by reason.",
+	}, []string{"reason"})
+
+	PublishedObjects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "published_objects_total",
+		Help:      "Playlists and segments uploaded to the publishing bucket.",
+	})
+
+	PublishErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "publish_errors_total",
+		Help:      "Uploads and deletions against the publishing bucket that failed after retries.",
+	})
+
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+)
+
+// RegisterJobQueue exports the length and capacity of the job queue.
+func RegisterJobQueue(length, capacity func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "job_queue_length",
+		Help:      "Jobs currently waiting on the job queue.",
+	}, func() float64 { return float64(length()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "job_queue_capacity",
+		Help:      "Capacity of the job queue.",
+	}, func() float64 { return float64(capacity()) })
+}
+
+// DeleteStream drops the per-stream series of a stream that has been
+// removed, so stopped streams do not linger in the exposition.
+func DeleteStream(streamID string) {
+	labels := prometheus.Labels{"stream_id": streamID}
+	ImagesProcessed.Delete(labels)
+	FFmpegRestarts.Delete(labels)
+	SegmentsProduced.Delete(labels)
+	LastSegmentTime.Delete(labels)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
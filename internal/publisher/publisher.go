@@ -0,0 +1,323 @@
+// Package publisher copies the HLS output of streams to an S3-compatible
+// bucket, so a CDN can serve viewers without routing them through the
+// streamer.
+package publisher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/abaddouh/poll-streamer/internal/metrics"
+)
+
+const (
+	// DefaultRegion is the signing region used when none is configured.
+	// S3-compatible stores usually accept any region.
+	DefaultRegion = "us-east-1"
+
+	playlistCacheControl = "no-cache"
+	// Segments are cached for long, but not marked immutable: a restored
+	// stream may reuse the names of segments published before the restart,
+	// so caches must still be able to revalidate them.
+	segmentCacheControl = "public, max-age=31536000"
+)
+
+// Config describes the bucket streams are published to.
+type Config struct {
+	// Endpoint is the base URL of the S3 API, e.g.
+	// https://s3.eu-west-1.amazonaws.com or http://minio:9000. Objects are
+	// addressed path-style, as Endpoint/Bucket/key.
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Prefix is prepended to the key of every object.
+	Prefix string
+	// PublicURL is the base URL viewers reach the bucket at, typically a
+	// CDN. It defaults to Endpoint/Bucket.
+	PublicURL string
+}
+
+// ReadFunc returns a file of a stream's output by its path within the
+// stream, failing with an error wrapping fs.ErrNotExist for missing files.
+type ReadFunc func(name string) ([]byte, error)
+
+// Publisher uploads the segments and playlists of streams to a bucket,
+// stored under <prefix><stream_id>/ with the same layout as the stream's
+// output directory.
+type Publisher struct {
+	client    *s3Client
+	prefix    string
+	publicURL string
+
+	mu      sync.Mutex
+	streams map[string]*streamState
+}
+
+// streamState is what has been published of a stream.
+type streamState struct {
+	mu sync.Mutex
+	// playlists holds the last published version of each playlist.
+	playlists map[string][]byte
+	// objects holds the segments and init segments uploaded so far.
+	objects map[string]bool
+}
+
+func New(cfg Config) (*Publisher, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q, expected an http:// or https:// URL", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("no S3 bucket configured")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("no S3 credentials configured")
+	}
+	region := cfg.Region
+	if region == "" {
+		region = DefaultRegion
+	}
+	publicURL := cfg.PublicURL
+	if publicURL == "" {
+		publicURL = strings.TrimRight(cfg.Endpoint, "/") + "/" + cfg.Bucket
+	}
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &Publisher{
+		client: &s3Client{
+			endpoint: endpoint,
+			bucket:   cfg.Bucket,
+			signer: sigV4{
+				accessKey: cfg.AccessKey,
+				secretKey: cfg.SecretKey,
+				region:    region,
+				service:   "s3",
+			},
+			http: &http.Client{},
+		},
+		prefix:    prefix,
+		publicURL: strings.TrimRight(publicURL, "/"),
+		streams:   make(map[string]*streamState),
+	}, nil
+}
+
+// key returns the object key of a file of a stream.
+func (p *Publisher) key(streamID, name string) string {
+	return p.prefix + streamID + "/" + name
+}
+
+// URL returns the public URL of a file of a stream.
+func (p *Publisher) URL(streamID, name string) string {
+	return p.publicURL + "/" + p.key(streamID, name)
+}
+
+func (p *Publisher) stream(streamID string) *streamState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st, ok := p.streams[streamID]
+	if !ok {
+		st = &streamState{
+			playlists: make(map[string][]byte),
+			objects:   make(map[string]bool),
+		}
+		p.streams[streamID] = st
+	}
+	return st
+}
+
+// Sync publishes the media playlists of a stream that changed since the
+// last call. The segments a playlist lists are uploaded before the
+// playlist itself, which is held back if any of them fails, and segments
+// that dropped out of it are deleted afterwards. The master playlist, if
+// any, is published once every media playlist has been.
+func (p *Publisher) Sync(ctx context.Context, streamID string, read ReadFunc, playlists []string, master string) error {
+	st := p.stream(streamID)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var firstErr error
+	ready := true
+	for _, name := range playlists {
+		if err := p.syncPlaylist(ctx, streamID, st, read, name); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if st.playlists[name] == nil {
+			ready = false
+		}
+	}
+	if master == "" || !ready || st.playlists[master] != nil {
+		return firstErr
+	}
+
+	data, err := read(master)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", master, err)
+	}
+	if err := p.upload(ctx, streamID, master, data, playlistCacheControl); err != nil {
+		return err
+	}
+	st.playlists[master] = data
+	return firstErr
+}
+
+func (p *Publisher) syncPlaylist(ctx context.Context, streamID string, st *streamState, read ReadFunc, name string) error {
+	playlist, err := read(name)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && len(playlist) == 0) {
+		// FFmpeg has not written it yet.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	if bytes.Equal(playlist, st.playlists[name]) {
+		return nil
+	}
+
+	dir := path.Dir(name)
+	listed := playlistFiles(dir, playlist)
+	for _, file := range listed {
+		if st.objects[file] {
+			continue
+		}
+		data, err := read(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			// The segment was deleted since the playlist was read, so a
+			// newer playlist is on its way.
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		if err := p.upload(ctx, streamID, file, data, segmentCacheControl); err != nil {
+			return err
+		}
+		st.objects[file] = true
+	}
+
+	if err := p.upload(ctx, streamID, name, playlist, playlistCacheControl); err != nil {
+		return err
+	}
+	st.playlists[name] = playlist
+
+	keep := make(map[string]bool, len(listed))
+	for _, file := range listed {
+		keep[file] = true
+	}
+	for file := range st.objects {
+		if keep[file] || path.Dir(file) != dir {
+			continue
+		}
+		if err := p.client.delete(ctx, p.key(streamID, file)); err != nil {
+			metrics.PublishErrors.Inc()
+			return fmt.Errorf("failed to delete %s: %v", file, err)
+		}
+		delete(st.objects, file)
+	}
+	return nil
+}
+
+// upload puts a file of a stream into the bucket.
+func (p *Publisher) upload(ctx context.Context, streamID, name string, data []byte, cacheControl string) error {
+	if err := p.client.put(ctx, p.key(streamID, name), data, contentType(name), cacheControl); err != nil {
+		metrics.PublishErrors.Inc()
+		return fmt.Errorf("failed to upload %s: %v", name, err)
+	}
+	metrics.PublishedObjects.Inc()
+	return nil
+}
+
+// DeleteStream removes everything published of a stream from the bucket,
+// playlists first so viewers never see a playlist whose segments are gone.
+func (p *Publisher) DeleteStream(ctx context.Context, streamID string) error {
+	p.mu.Lock()
+	st, ok := p.streams[streamID]
+	delete(p.streams, streamID)
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var firstErr error
+	for name := range st.playlists {
+		if err := p.client.delete(ctx, p.key(streamID, name)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for name := range st.objects {
+		if err := p.client.delete(ctx, p.key(streamID, name)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		metrics.PublishErrors.Inc()
+	}
+	return firstErr
+}
+
+// Forget drops what is known about a stream without touching the bucket,
+// for streams that are shut down to be resumed later.
+func (p *Publisher) Forget(streamID string) {
+	p.mu.Lock()
+	delete(p.streams, streamID)
+	p.mu.Unlock()
+}
+
+// contentType returns the MIME type of a playlist or segment.
+func contentType(name string) string {
+	switch path.Ext(name) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/MP2T"
+	case ".m4s":
+		return "video/iso.segment"
+	case ".mp4":
+		return "video/mp4"
+	}
+	return "application/octet-stream"
+}
+
+// playlistFiles returns the paths, relative to the stream, of the segments
+// and init segments an HLS media playlist in dir refers to, in order.
+func playlistFiles(dir string, playlist []byte) []string {
+	var files []string
+	add := func(uri string) {
+		if uri == "" || strings.Contains(uri, "://") {
+			return
+		}
+		uri, _, _ = strings.Cut(uri, "?")
+		files = append(files, path.Join(dir, uri))
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			if _, rest, ok := strings.Cut(line, `URI="`); ok {
+				uri, _, _ := strings.Cut(rest, `"`)
+				add(uri)
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			add(line)
+		}
+	}
+	return files
+}
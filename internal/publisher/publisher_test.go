@@ -0,0 +1,222 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 stands in for a bucket, recording the requests it receives in
+// order and failing uploads of the keys in fail.
+type fakeS3 struct {
+	mu       sync.Mutex
+	requests []string
+	objects  map[string]string
+	fail     map[string]bool
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKTEST/") {
+		http.Error(w, "missing signature", http.StatusForbidden)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/bucket/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	f.requests = append(f.requests, r.Method+" "+key)
+	switch r.Method {
+	case http.MethodPut:
+		if f.fail[key] {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		f.objects[key] = r.Header.Get("Content-Type")
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+	}
+}
+
+// takeRequests returns the requests received since the last call.
+func (f *fakeS3) takeRequests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	requests := f.requests
+	f.requests = nil
+	return requests
+}
+
+func newTestPublisher(t *testing.T) (*Publisher, *fakeS3) {
+	t.Helper()
+	bucket := &fakeS3{objects: make(map[string]string), fail: make(map[string]bool)}
+	srv := httptest.NewServer(bucket)
+	t.Cleanup(srv.Close)
+	p, err := New(Config{
+		Endpoint:  srv.URL,
+		Bucket:    "bucket",
+		AccessKey: "AKTEST",
+		SecretKey: "secret",
+		Prefix:    "live",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, bucket
+}
+
+// files is a stream's output directory.
+type files map[string]string
+
+func (f files) read(name string) ([]byte, error) {
+	data, ok := f[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return []byte(data), nil
+}
+
+func mediaPlaylist(first int, segments ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+	b.WriteString("#EXT-X-MAP:URI=\"init.mp4\"\n")
+	for _, segment := range segments {
+		fmt.Fprintf(&b, "#EXTINF:2.000000,\n%s\n", segment)
+	}
+	return b.String()
+}
+
+func TestSyncUploadsSegmentsBeforePlaylists(t *testing.T) {
+	p, bucket := newTestPublisher(t)
+	out := files{
+		"index.m3u8":          "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\n720p/index.m3u8\n",
+		"720p/init.mp4":       "init",
+		"720p/segment000.m4s": "0",
+		"720p/segment001.m4s": "1",
+		"720p/index.m3u8":     mediaPlaylist(0, "segment000.m4s", "segment001.m4s"),
+	}
+	playlists := []string{"720p/index.m3u8"}
+
+	if err := p.Sync(context.Background(), "s1", out.read, playlists, "index.m3u8"); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	want := []string{
+		"PUT live/s1/720p/init.mp4",
+		"PUT live/s1/720p/segment000.m4s",
+		"PUT live/s1/720p/segment001.m4s",
+		"PUT live/s1/720p/index.m3u8",
+		"PUT live/s1/index.m3u8",
+	}
+	if got := bucket.takeRequests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("first sync sent %q, want %q", got, want)
+	}
+	if got := bucket.objects["live/s1/720p/index.m3u8"]; got != "application/vnd.apple.mpegurl" {
+		t.Errorf("playlist Content-Type = %q", got)
+	}
+
+	// segment000 falls off the window.
+	out["720p/segment002.m4s"] = "2"
+	out["720p/index.m3u8"] = mediaPlaylist(1, "segment001.m4s", "segment002.m4s")
+	delete(out, "720p/segment000.m4s")
+	if err := p.Sync(context.Background(), "s1", out.read, playlists, "index.m3u8"); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	want = []string{
+		"PUT live/s1/720p/segment002.m4s",
+		"PUT live/s1/720p/index.m3u8",
+		"DELETE live/s1/720p/segment000.m4s",
+	}
+	if got := bucket.takeRequests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("second sync sent %q, want %q", got, want)
+	}
+
+	// Nothing changed.
+	if err := p.Sync(context.Background(), "s1", out.read, playlists, "index.m3u8"); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	if got := bucket.takeRequests(); len(got) != 0 {
+		t.Errorf("unchanged sync sent %q, want nothing", got)
+	}
+}
+
+func TestSyncHoldsBackPlaylistWhenASegmentFails(t *testing.T) {
+	p, bucket := newTestPublisher(t)
+	out := files{
+		"init.mp4":       "init",
+		"segment000.m4s": "0",
+		"segment001.m4s": "1",
+		"index.m3u8":     mediaPlaylist(0, "segment000.m4s", "segment001.m4s"),
+	}
+	bucket.fail["live/s1/segment001.m4s"] = true
+
+	if err := p.Sync(context.Background(), "s1", out.read, []string{"index.m3u8"}, ""); err == nil {
+		t.Fatal("Sync() succeeded, want an error")
+	}
+	want := []string{
+		"PUT live/s1/init.mp4",
+		"PUT live/s1/segment000.m4s",
+		"PUT live/s1/segment001.m4s",
+	}
+	if got := bucket.takeRequests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("failed sync sent %q, want %q", got, want)
+	}
+
+	// The next sync retries the failed segment only, then the playlist.
+	delete(bucket.fail, "live/s1/segment001.m4s")
+	if err := p.Sync(context.Background(), "s1", out.read, []string{"index.m3u8"}, ""); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	want = []string{
+		"PUT live/s1/segment001.m4s",
+		"PUT live/s1/index.m3u8",
+	}
+	if got := bucket.takeRequests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("retried sync sent %q, want %q", got, want)
+	}
+}
+
+func TestDeleteStreamRemovesPlaylistsFirst(t *testing.T) {
+	p, bucket := newTestPublisher(t)
+	out := files{
+		"segment000.ts": "0",
+		"segment001.ts": "1",
+		"index.m3u8":    "#EXTM3U\n#EXTINF:2,\nsegment000.ts\n#EXTINF:2,\nsegment001.ts\n",
+	}
+	if err := p.Sync(context.Background(), "s1", out.read, []string{"index.m3u8"}, ""); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	bucket.takeRequests()
+
+	if err := p.DeleteStream(context.Background(), "s1"); err != nil {
+		t.Fatalf("DeleteStream() = %v", err)
+	}
+	got := bucket.takeRequests()
+	if len(got) != 3 || got[0] != "DELETE live/s1/index.m3u8" {
+		t.Errorf("DeleteStream() sent %q, want the playlist deleted before its segments", got)
+	}
+	if len(bucket.objects) != 0 {
+		t.Errorf("objects left in the bucket: %v", bucket.objects)
+	}
+}
+
+func TestPlaylistFiles(t *testing.T) {
+	playlist := []byte(mediaPlaylist(3, "segment003.m4s", "segment004.m4s?token=abc", "https://cdn.example.com/segment005.m4s"))
+	got := playlistFiles("720p", playlist)
+	want := []string{"720p/init.mp4", "720p/segment003.m4s", "720p/segment004.m4s"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("playlistFiles() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,220 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxAttempts bounds how often a request is tried before giving up.
+	maxAttempts = 4
+	// retryDelay is the wait before the first retry, doubled for each
+	// further one.
+	retryDelay = 250 * time.Millisecond
+	// requestTimeout bounds a single request to the bucket.
+	requestTimeout = 30 * time.Second
+	// amzDateFormat is the layout of the X-Amz-Date header.
+	amzDateFormat = "20060102T150405Z"
+)
+
+// s3Client issues path-style requests signed with AWS Signature Version 4,
+// which AWS S3 and S3-compatible stores such as MinIO all accept.
+type s3Client struct {
+	endpoint *url.URL
+	bucket   string
+	signer   sigV4
+	http     *http.Client
+}
+
+// sigV4 signs requests to a service in a region with AWS Signature Version
+// 4.
+type sigV4 struct {
+	accessKey string
+	secretKey string
+	region    string
+	service   string
+}
+
+// statusError is a request the bucket answered with an error status.
+type statusError struct {
+	method string
+	key    string
+	code   int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.method, e.key, e.code, e.body)
+}
+
+// retryable reports whether a failed request may succeed when repeated.
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= 500 || se.code == http.StatusTooManyRequests || se.code == http.StatusRequestTimeout
+	}
+	return true
+}
+
+// put uploads an object.
+func (c *s3Client) put(ctx context.Context, key string, data []byte, contentType, cacheControl string) error {
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	header.Set("Cache-Control", cacheControl)
+	return c.do(ctx, http.MethodPut, key, data, header)
+}
+
+// delete removes an object. Deleting a missing object succeeds.
+func (c *s3Client) delete(ctx context.Context, key string) error {
+	err := c.do(ctx, http.MethodDelete, key, nil, http.Header{})
+	var se *statusError
+	if errors.As(err, &se) && se.code == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// do sends a request, retrying with exponential backoff on network errors,
+// throttling and server errors.
+func (c *s3Client) do(ctx context.Context, method, key string, body []byte, header http.Header) error {
+	var err error
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		err = c.send(ctx, method, key, body, header)
+		if err == nil || !retryable(err) || attempt == maxAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// send signs and sends a single request.
+func (c *s3Client) send(ctx context.Context, method, key string, body []byte, header http.Header) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	u := *c.endpoint
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + c.bucket + "/" + key
+	// Send the path exactly as it is signed.
+	u.RawPath = escapePath(u.Path)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	c.sign(req, body, time.Now().UTC())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &statusError{method: method, key: key, code: resp.StatusCode, body: strings.TrimSpace(string(msg))}
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// sign adds the AWS Signature Version 4 headers to a request.
+func (c *s3Client) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format(amzDateFormat)
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("Authorization", c.signer.authorization(req.Method, req.URL.EscapedPath(), map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}, payloadHash, now))
+}
+
+// authorization returns the Authorization header of a request without a
+// query string, signed over the given headers keyed by lowercase name.
+func (s sigV4) authorization(method, escapedPath string, headers map[string]string, payloadHash string, now time.Time) string {
+	now = now.UTC()
+	date := now.Format("20060102")
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		escapedPath,
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/" + s.service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format(amzDateFormat),
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	return fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature)
+}
+
+// escapePath URI-encodes a path as Signature Version 4 requires, leaving
+// only unreserved characters and slashes as they are.
+func escapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,322 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Export formats.
+const (
+	FormatMP4 = "mp4"
+	FormatHLS = "hls"
+)
+
+// Export states.
+const (
+	StateQueued  = "queued"
+	StateRunning = "running"
+	StateDone    = "done"
+	StateFailed  = "failed"
+)
+
+const (
+	// defaultExportFPS is the frame rate of exports that do not set one.
+	defaultExportFPS = 10
+	// maxExportFPS bounds the frame rate of exports.
+	maxExportFPS = 60
+	// vodSegmentTime is the segment duration of HLS VOD exports, in seconds.
+	vodSegmentTime = 4
+	// statusFile holds the state of an export next to its output.
+	statusFile = "status.json"
+)
+
+// ExportRequest selects the frames to export and how to render them.
+type ExportRequest struct {
+	From   time.Time `json:"from,omitempty"`
+	To     time.Time `json:"to,omitempty"`
+	Format string    `json:"format,omitempty"`
+	// FPS is the frame rate of the output. In a time-lapse every archived
+	// frame lasts one output frame.
+	FPS int `json:"fps,omitempty"`
+	// Realtime holds every frame for as long as it was live instead of
+	// rendering a time-lapse.
+	Realtime bool `json:"realtime,omitempty"`
+}
+
+// Export is the state of a rendering of part of a recording.
+type Export struct {
+	ID         string     `json:"id"`
+	StreamID   string     `json:"stream_id"`
+	Format     string     `json:"format"`
+	From       time.Time  `json:"from"`
+	To         time.Time  `json:"to"`
+	FPS        int        `json:"fps"`
+	Realtime   bool       `json:"realtime,omitempty"`
+	Frames     int        `json:"frames"`
+	State      string     `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	File       string     `json:"file,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// outputFile returns the name of the file players open for an export.
+func outputFile(format string) string {
+	if format == FormatHLS {
+		return "playlist.m3u8"
+	}
+	return "export.mp4"
+}
+
+// StartExport validates an export request and queues its rendering. The
+// returned export is queued; poll Export for its progress.
+func (r *Recorder) StartExport(streamID string, req ExportRequest) (Export, error) {
+	if req.Format == "" {
+		req.Format = FormatMP4
+	}
+	if req.Format != FormatMP4 && req.Format != FormatHLS {
+		return Export{}, fmt.Errorf("format must be %s or %s", FormatMP4, FormatHLS)
+	}
+	if req.FPS == 0 {
+		req.FPS = defaultExportFPS
+	}
+	if req.FPS < 0 || req.FPS > maxExportFPS {
+		return Export{}, fmt.Errorf("fps must be between 1 and %d", maxExportFPS)
+	}
+	if !req.From.IsZero() && !req.To.IsZero() && req.To.Before(req.From) {
+		return Export{}, errors.New("to must not be before from")
+	}
+
+	// Register the export before listing its frames, so retention cannot
+	// prune them between the listing and FFmpeg reading them.
+	exportID := uuid.New().String()
+	r.mu.Lock()
+	if len(r.running) >= maxPendingExports {
+		r.mu.Unlock()
+		return Export{}, ErrBusy
+	}
+	r.running[exportID] = streamID
+	r.mu.Unlock()
+	unregister := func() {
+		r.mu.Lock()
+		delete(r.running, exportID)
+		r.mu.Unlock()
+	}
+
+	frames, err := r.Frames(streamID, req.From, req.To)
+	if err != nil {
+		unregister()
+		return Export{}, err
+	}
+	if len(frames) == 0 {
+		unregister()
+		return Export{}, errors.New("no frames recorded in the requested range")
+	}
+
+	export := Export{
+		ID:        exportID,
+		StreamID:  streamID,
+		Format:    req.Format,
+		From:      frames[0].At,
+		To:        frames[len(frames)-1].At,
+		FPS:       req.FPS,
+		Realtime:  req.Realtime,
+		Frames:    len(frames),
+		State:     StateQueued,
+		CreatedAt: time.Now(),
+	}
+	dir := r.exportDir(streamID, export.ID)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		err = fmt.Errorf("failed to create export directory: %v", err)
+	} else {
+		err = writeStatus(dir, export)
+	}
+	if err != nil {
+		unregister()
+		return Export{}, err
+	}
+
+	r.wg.Add(1)
+	go r.render(dir, export, frames)
+	return export, nil
+}
+
+// Export returns the state of an export.
+func (r *Recorder) Export(streamID, exportID string) (Export, error) {
+	if !validID.MatchString(exportID) {
+		return Export{}, ErrNotFound
+	}
+	if _, err := r.streamDir(streamID); err != nil {
+		return Export{}, err
+	}
+	data, err := os.ReadFile(filepath.Join(r.exportDir(streamID, exportID), statusFile))
+	if os.IsNotExist(err) {
+		return Export{}, ErrNotFound
+	}
+	if err != nil {
+		return Export{}, fmt.Errorf("failed to read export status: %v", err)
+	}
+	var export Export
+	if err := json.Unmarshal(data, &export); err != nil {
+		return Export{}, fmt.Errorf("failed to parse export status: %v", err)
+	}
+
+	r.mu.Lock()
+	_, running := r.running[exportID]
+	r.mu.Unlock()
+	if !running && (export.State == StateQueued || export.State == StateRunning) {
+		// The server stopped while the export was being rendered.
+		export.State = StateFailed
+		export.Error = "interrupted"
+	}
+	return export, nil
+}
+
+// ExportFile returns the path of a file produced by a finished export.
+func (r *Recorder) ExportFile(streamID, exportID, name string) (string, error) {
+	export, err := r.Export(streamID, exportID)
+	if err != nil {
+		return "", err
+	}
+	if export.State != StateDone || name == statusFile || name != filepath.Base(name) {
+		return "", ErrNotFound
+	}
+	path := filepath.Join(r.exportDir(streamID, exportID), name)
+	if _, err := os.Stat(path); err != nil {
+		return "", ErrNotFound
+	}
+	return path, nil
+}
+
+func (r *Recorder) exportDir(streamID, exportID string) string {
+	return filepath.Join(r.root, streamID, "exports", exportID)
+}
+
+// render waits for a free slot and runs FFmpeg for an export, recording the
+// outcome in its status file.
+func (r *Recorder) render(dir string, export Export, frames []Frame) {
+	defer r.wg.Done()
+	defer func() {
+		r.mu.Lock()
+		delete(r.running, export.ID)
+		r.mu.Unlock()
+	}()
+
+	select {
+	case r.slots <- struct{}{}:
+		defer func() { <-r.slots }()
+	case <-r.ctx.Done():
+		return
+	}
+
+	export.State = StateRunning
+	if err := writeStatus(dir, export); err != nil {
+		log.Printf("Export %s: %v", export.ID, err)
+	}
+
+	err := r.runFFmpeg(dir, export, frames)
+	finishedAt := time.Now()
+	export.FinishedAt = &finishedAt
+	if err != nil {
+		log.Printf("Export %s of stream %s failed: %v", export.ID, export.StreamID, err)
+		export.State = StateFailed
+		export.Error = err.Error()
+	} else {
+		log.Printf("Export %s of stream %s finished (%d frames)", export.ID, export.StreamID, export.Frames)
+		export.State = StateDone
+		export.File = outputFile(export.Format)
+	}
+	if err := writeStatus(dir, export); err != nil {
+		log.Printf("Export %s: %v", export.ID, err)
+	}
+}
+
+// runFFmpeg renders the frames of an export through FFmpeg's concat
+// demuxer, which shows each frame for the duration given in the list.
+func (r *Recorder) runFFmpeg(dir string, export Export, frames []Frame) error {
+	listPath := filepath.Join(dir, "frames.txt")
+	if err := os.WriteFile(listPath, []byte(concatList(frames, export.FPS, export.Realtime)), 0644); err != nil {
+		return fmt.Errorf("failed to write frame list: %v", err)
+	}
+	defer os.Remove(listPath)
+
+	args := []string{
+		"-y",
+		"-nostats",
+		"-f", "concat",
+		"-safe", "0",
+		"-i", listPath,
+		"-vf", fmt.Sprintf("fps=%d,format=yuv420p", export.FPS),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+	}
+	if export.Format == FormatHLS {
+		args = append(args,
+			"-g", fmt.Sprintf("%d", export.FPS*vodSegmentTime),
+			"-f", "hls",
+			"-hls_time", fmt.Sprintf("%d", vodSegmentTime),
+			"-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(dir, "segment%05d.ts"),
+		)
+	} else {
+		args = append(args, "-movflags", "+faststart")
+	}
+	args = append(args, filepath.Join(dir, outputFile(export.Format)))
+
+	cmd := exec.CommandContext(r.ctx, "ffmpeg", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		return fmt.Errorf("FFmpeg failed: %v: %s", err, lines[len(lines)-1])
+	}
+	return nil
+}
+
+// concatList returns an FFmpeg concat script showing every frame for 1/fps
+// seconds, or until the next frame arrived if realtime is set.
+func concatList(frames []Frame, fps int, realtime bool) string {
+	frameTime := 1 / float64(fps)
+	var b strings.Builder
+	b.WriteString("ffconcat version 1.0\n")
+	for i, f := range frames {
+		duration := frameTime
+		if realtime && i+1 < len(frames) {
+			duration = frames[i+1].At.Sub(f.At).Seconds()
+		}
+		fmt.Fprintf(&b, "file %s\nduration %.6f\n", quoteConcat(f.Path), duration)
+	}
+	// The last frame has to be listed twice for its duration to apply.
+	fmt.Fprintf(&b, "file %s\n", quoteConcat(frames[len(frames)-1].Path))
+	return b.String()
+}
+
+// quoteConcat quotes a path for an FFmpeg concat script.
+func quoteConcat(path string) string {
+	return "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+}
+
+// writeStatus atomically replaces the status file of an export.
+func writeStatus(dir string, export Export) error {
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, statusFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write export status: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, statusFile)); err != nil {
+		return fmt.Errorf("failed to write export status: %v", err)
+	}
+	return nil
+}
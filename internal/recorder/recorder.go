@@ -0,0 +1,231 @@
+// Package recorder archives the frames of recorded streams and renders time
+// ranges of them into MP4 time-lapses or HLS VOD playlists, so streams can
+// be replayed after they end.
+package recorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxConcurrentExports bounds how many exports FFmpeg renders at once;
+// further exports wait their turn.
+const maxConcurrentExports = 2
+
+// maxPendingExports bounds how many exports can be queued or running at
+// once; further exports are rejected with ErrBusy.
+const maxPendingExports = 16
+
+var (
+	// ErrNotFound is returned for unknown recordings, exports and files.
+	ErrNotFound = errors.New("not found")
+	// ErrBusy is returned when too many exports are already pending.
+	ErrBusy = errors.New("too many exports pending")
+)
+
+// validID restricts stream and export IDs to safe directory names.
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Frame is an archived frame and the time it arrived.
+type Frame struct {
+	Path string
+	At   time.Time
+}
+
+// Recording summarises the archive of a stream.
+type Recording struct {
+	StreamID   string     `json:"stream_id"`
+	Frames     int        `json:"frames"`
+	FirstFrame *time.Time `json:"first_frame_at,omitempty"`
+	LastFrame  *time.Time `json:"last_frame_at,omitempty"`
+	Exports    []Export   `json:"exports"`
+}
+
+// Recorder stores archived frames under root/<stream_id>/frames and exports
+// under root/<stream_id>/exports/<export_id>.
+type Recorder struct {
+	root      string
+	retention Retention
+	ctx       context.Context
+	cancel    context.CancelFunc
+	slots     chan struct{}
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	// running maps the IDs of queued and running exports to their stream.
+	running map[string]string
+	// archives tracks the frames of recordings when retention is enabled.
+	archives map[string]*archive
+}
+
+// New creates a recorder archiving frames under root and deleting them once
+// they fall outside retention.
+func New(root string, retention Retention) (*Recorder, error) {
+	// FFmpeg resolves the frames listed in an export relative to the list.
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recordings directory: %v", err)
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create recordings directory %s: %v", root, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Recorder{
+		root:      root,
+		retention: retention,
+		ctx:       ctx,
+		cancel:    cancel,
+		slots:     make(chan struct{}, maxConcurrentExports),
+		running:   make(map[string]string),
+		archives:  make(map[string]*archive),
+	}
+	if retention.MaxAge > 0 {
+		r.wg.Add(1)
+		go r.enforceRetention()
+	}
+	return r, nil
+}
+
+// Close aborts running exports and waits for them, and the retention
+// sweep, to finish.
+func (r *Recorder) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+func (r *Recorder) streamDir(streamID string) (string, error) {
+	if !validID.MatchString(streamID) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(r.root, streamID), nil
+}
+
+// Record archives a JPEG frame of a stream with its arrival time.
+func (r *Recorder) Record(streamID string, frame []byte, at time.Time) error {
+	dir, err := r.streamDir(streamID)
+	if err != nil {
+		return fmt.Errorf("invalid stream ID %q", streamID)
+	}
+	dir = filepath.Join(dir, "frames")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create frames directory: %v", err)
+	}
+
+	// Zero-padded nanoseconds sort in arrival order.
+	name := filepath.Join(dir, fmt.Sprintf("%019d.jpg", at.UnixNano()))
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, frame, 0644); err != nil {
+		return fmt.Errorf("failed to write frame: %v", err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write frame: %v", err)
+	}
+	if r.retention.enabled() {
+		r.track(streamID, archivedFrame{name: filepath.Base(name), at: at, size: int64(len(frame))})
+	}
+	return nil
+}
+
+// Frames returns the frames of a stream that arrived in [from, to], oldest
+// first. Zero bounds are open.
+func (r *Recorder) Frames(streamID string, from, to time.Time) ([]Frame, error) {
+	dir, err := r.streamDir(streamID)
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Join(dir, "frames")
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list frames: %v", err)
+	}
+
+	var frames []Frame
+	for _, entry := range entries {
+		stamp, ok := strings.CutSuffix(entry.Name(), ".jpg")
+		if !ok {
+			continue
+		}
+		nanos, err := strconv.ParseInt(stamp, 10, 64)
+		if err != nil {
+			continue
+		}
+		at := time.Unix(0, nanos)
+		if (!from.IsZero() && at.Before(from)) || (!to.IsZero() && at.After(to)) {
+			continue
+		}
+		frames = append(frames, Frame{Path: filepath.Join(dir, entry.Name()), At: at})
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i].At.Before(frames[j].At) })
+	return frames, nil
+}
+
+// Recording summarises the archive of a stream.
+func (r *Recorder) Recording(streamID string) (Recording, error) {
+	frames, err := r.Frames(streamID, time.Time{}, time.Time{})
+	if err != nil {
+		return Recording{}, err
+	}
+	rec := Recording{StreamID: streamID, Frames: len(frames), Exports: []Export{}}
+	if len(frames) > 0 {
+		first, last := frames[0].At, frames[len(frames)-1].At
+		rec.FirstFrame, rec.LastFrame = &first, &last
+	}
+
+	dir, _ := r.streamDir(streamID)
+	entries, err := os.ReadDir(filepath.Join(dir, "exports"))
+	if err != nil && !os.IsNotExist(err) {
+		return Recording{}, fmt.Errorf("failed to list exports: %v", err)
+	}
+	for _, entry := range entries {
+		if export, err := r.Export(streamID, entry.Name()); err == nil {
+			rec.Exports = append(rec.Exports, export)
+		}
+	}
+	sort.Slice(rec.Exports, func(i, j int) bool { return rec.Exports[i].CreatedAt.Before(rec.Exports[j].CreatedAt) })
+	return rec, nil
+}
+
+// List returns the IDs of every stream with a recording.
+func (r *Recorder) List() ([]string, error) {
+	entries, err := os.ReadDir(r.root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recordings: %v", err)
+	}
+	ids := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && validID.MatchString(entry.Name()) {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids, nil
+}
+
+// Delete removes the recording of a stream and all its exports.
+func (r *Recorder) Delete(streamID string) error {
+	dir, err := r.streamDir(streamID)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	r.mu.Lock()
+	delete(r.archives, streamID)
+	r.mu.Unlock()
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove recording: %v", err)
+	}
+	return nil
+}
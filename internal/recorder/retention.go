@@ -0,0 +1,172 @@
+package recorder
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retentionInterval is how often recordings are swept for frames older than
+// the retention period.
+const retentionInterval = time.Minute
+
+// Retention bounds what a recording keeps. Zero fields are unbounded.
+type Retention struct {
+	// MaxAge is how long a frame is kept after it arrived.
+	MaxAge time.Duration
+	// MaxBytes caps the size of the frames of each recording. The oldest
+	// frames are deleted first and the newest frame is always kept.
+	MaxBytes int64
+}
+
+func (r Retention) enabled() bool {
+	return r.MaxAge > 0 || r.MaxBytes > 0
+}
+
+// archivedFrame is a frame file counted towards the retention limits.
+type archivedFrame struct {
+	name string
+	at   time.Time
+	size int64
+}
+
+// archive tracks the frames of a recording, oldest first, along with their
+// total size.
+type archive struct {
+	frames []archivedFrame
+	bytes  int64
+}
+
+// loadArchive lists the frames already on disk in a frames directory.
+func loadArchive(dir string) (*archive, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	a := &archive{}
+	for _, entry := range entries {
+		stamp, ok := strings.CutSuffix(entry.Name(), ".jpg")
+		if !ok {
+			continue
+		}
+		nanos, err := strconv.ParseInt(stamp, 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		a.frames = append(a.frames, archivedFrame{name: entry.Name(), at: time.Unix(0, nanos), size: info.Size()})
+		a.bytes += info.Size()
+	}
+	sort.Slice(a.frames, func(i, j int) bool { return a.frames[i].at.Before(a.frames[j].at) })
+	return a, nil
+}
+
+// archiveLocked returns the tracked frames of a stream, listing them from
+// disk the first time. r.mu must be held.
+func (r *Recorder) archiveLocked(streamID string) (*archive, error) {
+	if a := r.archives[streamID]; a != nil {
+		return a, nil
+	}
+	a, err := loadArchive(filepath.Join(r.root, streamID, "frames"))
+	if err != nil {
+		return nil, err
+	}
+	r.archives[streamID] = a
+	return a, nil
+}
+
+// exportingLocked reports whether an export of a stream is queued or
+// running. r.mu must be held.
+func (r *Recorder) exportingLocked(streamID string) bool {
+	for _, id := range r.running {
+		if id == streamID {
+			return true
+		}
+	}
+	return false
+}
+
+// track counts a newly archived frame and deletes the frames of the
+// recording that fall outside the retention limits.
+func (r *Recorder) track(streamID string, frame archivedFrame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, loaded := r.archives[streamID]
+	a, err := r.archiveLocked(streamID)
+	if err != nil {
+		log.Printf("Error listing frames of recording %s: %v", streamID, err)
+		return
+	}
+	if loaded {
+		a.frames = append(a.frames, frame)
+		a.bytes += frame.size
+	}
+	r.pruneLocked(streamID, a, frame.at)
+}
+
+// pruneLocked deletes the oldest frames of a recording until it is within
+// the retention limits. Recordings with an export queued or running are
+// left alone until the export finishes, so FFmpeg never loses frames it
+// was given. r.mu must be held.
+func (r *Recorder) pruneLocked(streamID string, a *archive, now time.Time) {
+	if r.exportingLocked(streamID) {
+		return
+	}
+	dir := filepath.Join(r.root, streamID, "frames")
+	cutoff := now.Add(-r.retention.MaxAge)
+	n := 0
+	for ; n < len(a.frames); n++ {
+		f := a.frames[n]
+		expired := r.retention.MaxAge > 0 && f.at.Before(cutoff)
+		oversized := r.retention.MaxBytes > 0 && a.bytes > r.retention.MaxBytes && n < len(a.frames)-1
+		if !expired && !oversized {
+			break
+		}
+		if err := os.Remove(filepath.Join(dir, f.name)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error pruning frame %s of recording %s: %v", f.name, streamID, err)
+			break
+		}
+		a.bytes -= f.size
+	}
+	if n > 0 {
+		a.frames = append([]archivedFrame(nil), a.frames[n:]...)
+	}
+}
+
+// enforceRetention periodically deletes expired frames from every
+// recording, including those of streams that no longer receive frames.
+func (r *Recorder) enforceRetention() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ids, err := r.List()
+			if err != nil {
+				log.Printf("Error applying recording retention: %v", err)
+				continue
+			}
+			now := time.Now()
+			r.mu.Lock()
+			for _, id := range ids {
+				a, err := r.archiveLocked(id)
+				if err != nil {
+					log.Printf("Error listing frames of recording %s: %v", id, err)
+					continue
+				}
+				r.pruneLocked(id, a, now)
+			}
+			r.mu.Unlock()
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
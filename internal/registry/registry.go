@@ -0,0 +1,201 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/abaddouh/poll-streamer/internal/streamer"
+)
+
+// Record describes a stream that should exist across restarts.
+type Record struct {
+	ID          string                  `json:"id"`
+	Params      streamer.EncodingParams `json:"params"`
+	CreatedAt   time.Time               `json:"created_at"`
+	TTL         Duration                `json:"ttl,omitempty"`
+	IdleTimeout Duration                `json:"idle_timeout,omitempty"`
+	Sources     []Source                `json:"sources,omitempty"`
+}
+
+// Source describes a source a stream's frames are fetched from, besides
+// its image directory and uploads.
+type Source struct {
+	Type     string            `json:"type"`
+	URL      string            `json:"url,omitempty"`
+	Interval Duration          `json:"interval,omitempty"`
+	Timeout  Duration          `json:"timeout,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	// Path is relative to the image directory.
+	Path string `json:"path,omitempty"`
+}
+
+// Registry stores the streams known to the server.
+type Registry interface {
+	// Put adds or replaces a record.
+	Put(rec Record) error
+	// Get returns a record, reporting false if there is none with that ID.
+	Get(id string) (Record, bool)
+	// Delete removes a record. Deleting an unknown record is not an error.
+	Delete(id string) error
+	// List returns every record, oldest first.
+	List() ([]Record, error)
+	// Persistent reports whether records survive a restart.
+	Persistent() bool
+}
+
+// MemoryRegistry keeps records in memory only.
+type MemoryRegistry struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{records: make(map[string]Record)}
+}
+
+func (m *MemoryRegistry) Put(rec Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[rec.ID] = rec
+	return nil
+}
+
+func (m *MemoryRegistry) Get(id string) (Record, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.records[id]
+	return rec, ok
+}
+
+func (m *MemoryRegistry) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, id)
+	return nil
+}
+
+func (m *MemoryRegistry) List() ([]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sortedRecords(m.records), nil
+}
+
+func (m *MemoryRegistry) Persistent() bool {
+	return false
+}
+
+// FileRegistry keeps records in memory and mirrors every change to a JSON
+// file, which is replaced atomically on each write.
+type FileRegistry struct {
+	path    string
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+// NewFileRegistry opens the registry stored at path, creating it if it does
+// not exist yet.
+func NewFileRegistry(path string) (*FileRegistry, error) {
+	r := &FileRegistry{
+		path:    path,
+		records: make(map[string]Record),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading registry %s: %v", path, err)
+	}
+
+	var records []Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("error parsing registry %s: %v", path, err)
+	}
+	for _, rec := range records {
+		r.records[rec.ID] = rec
+	}
+	return r, nil
+}
+
+func (r *FileRegistry) Put(rec Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records[rec.ID] = rec
+	return r.save()
+}
+
+func (r *FileRegistry) Get(id string) (Record, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.records[id]
+	return rec, ok
+}
+
+func (r *FileRegistry) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.records[id]; !exists {
+		return nil
+	}
+	delete(r.records, id)
+	return r.save()
+}
+
+func (r *FileRegistry) List() ([]Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return sortedRecords(r.records), nil
+}
+
+func (r *FileRegistry) Persistent() bool {
+	return true
+}
+
+// save writes the records to a temporary file and renames it over the
+// registry file so a crash never leaves a truncated registry behind.
+func (r *FileRegistry) save() error {
+	data, err := json.MarshalIndent(sortedRecords(r.records), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding registry: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error writing registry: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing registry: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing registry: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing registry: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), r.path); err != nil {
+		return fmt.Errorf("error writing registry: %v", err)
+	}
+	return nil
+}
+
+// sortedRecords returns the records ordered by creation time.
+func sortedRecords(records map[string]Record) []Record {
+	out := make([]Record, 0, len(records))
+	for _, rec := range records {
+		out = append(out, rec)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out
+}
@@ -0,0 +1,270 @@
+// Package segstore keeps the live playlists and segments of streams in
+// memory. FFmpeg uploads them over HTTP and they are served straight from
+// RAM, so neither the encoder nor viewers touch the disk.
+package segstore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abaddouh/poll-streamer/internal/hls"
+	"github.com/abaddouh/poll-streamer/internal/metrics"
+)
+
+// TokenHeader is the request header carrying the store's token on uploads.
+const TokenHeader = "X-Ingest-Token"
+
+// File is a stored playlist or segment.
+type File struct {
+	Data    []byte
+	ModTime time.Time
+	seq     uint64
+}
+
+// Store holds the files of every stream, keyed by stream ID and the file's
+// path within the stream, e.g. "720p/segment007.ts". Segments dropped from
+// their playlist are evicted as soon as the playlist is replaced, and the
+// oldest segments are evicted whenever the total size exceeds the limit.
+// Segments evicted for space are removed from the playlists listing them,
+// so players never request them.
+type Store struct {
+	maxBytes int64
+	token    string
+
+	mu      sync.Mutex
+	size    int64
+	seq     uint64
+	streams map[string]map[string]*File
+}
+
+// New creates a store holding at most maxBytes of segments.
+func New(maxBytes int64) *Store {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return &Store{
+		maxBytes: maxBytes,
+		token:    hex.EncodeToString(b),
+		streams:  make(map[string]map[string]*File),
+	}
+}
+
+// Token returns the secret FFmpeg presents when writing to the store, so
+// only encoders started by this process can upload.
+func (s *Store) Token() string {
+	return s.token
+}
+
+// isPlaylist reports whether name is a playlist, which is never evicted for
+// space.
+func isPlaylist(name string) bool {
+	return strings.HasSuffix(name, ".m3u8")
+}
+
+// isSegment reports whether name is a media segment. Only media segments
+// are evicted for space; playlists and init segments stay as long as the
+// playlists referring to them.
+func isSegment(name string) bool {
+	return strings.HasSuffix(name, ".ts") || strings.HasSuffix(name, ".m4s")
+}
+
+// Open starts accepting files for a stream.
+func (s *Store) Open(streamID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams[streamID] == nil {
+		s.streams[streamID] = make(map[string]*File)
+	}
+}
+
+// Put stores a file, replacing any previous version. It reports false if
+// the stream is not open, for instance because an upload raced with the
+// stream being stopped.
+func (s *Store) Put(streamID, name string, data []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := s.streams[streamID]
+	if files == nil {
+		return false
+	}
+	s.remove(files, name)
+	if isPlaylist(name) {
+		// FFmpeg keeps listing segments evicted for space.
+		data = trimMissing(files, path.Dir(name), data)
+	}
+	s.seq++
+	files[name] = &File{Data: data, ModTime: time.Now(), seq: s.seq}
+	s.size += int64(len(data))
+
+	if isPlaylist(name) {
+		s.evictUnlisted(files, name, data)
+	}
+	s.evictForSpace()
+	metrics.SegmentStoreBytes.Set(float64(s.size))
+	return true
+}
+
+// Get returns a stored file.
+func (s *Store) Get(streamID, name string) (File, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.streams[streamID][name]
+	if !ok {
+		return File{}, false
+	}
+	return *f, true
+}
+
+// Delete removes a file.
+func (s *Store) Delete(streamID, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if files := s.streams[streamID]; files != nil {
+		s.remove(files, name)
+	}
+	metrics.SegmentStoreBytes.Set(float64(s.size))
+}
+
+// DeleteStream removes every file of a stream and stops accepting new ones.
+func (s *Store) DeleteStream(streamID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.streams[streamID] {
+		s.size -= int64(len(f.Data))
+	}
+	delete(s.streams, streamID)
+	metrics.SegmentStoreBytes.Set(float64(s.size))
+}
+
+// Segments returns the number of media segments held for a stream.
+func (s *Store) Segments(streamID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for name := range s.streams[streamID] {
+		if isSegment(name) {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Store) remove(files map[string]*File, name string) {
+	if f, ok := files[name]; ok {
+		s.size -= int64(len(f.Data))
+		delete(files, name)
+	}
+}
+
+// evictUnlisted drops the segments next to a playlist that are older than
+// every media segment it lists. Newer unlisted segments are still being
+// written and will appear in the next version of the playlist.
+func (s *Store) evictUnlisted(files map[string]*File, playlistName string, playlist []byte) {
+	dir := path.Dir(playlistName)
+	listed := listedFiles(dir, playlist)
+	if len(listed) == 0 {
+		return
+	}
+	var oldest uint64
+	for name, media := range listed {
+		if f, ok := files[name]; ok && media && (oldest == 0 || f.seq < oldest) {
+			oldest = f.seq
+		}
+	}
+	for name, f := range files {
+		if _, ok := listed[name]; ok || isPlaylist(name) || path.Dir(name) != dir || f.seq >= oldest {
+			continue
+		}
+		s.remove(files, name)
+		metrics.SegmentStoreEvictions.WithLabelValues("unlisted").Inc()
+	}
+}
+
+// evictForSpace drops the oldest segments of any stream until the store
+// fits its limit, and removes them from the playlists listing them.
+func (s *Store) evictForSpace() {
+	for s.size > s.maxBytes {
+		var oldestFiles map[string]*File
+		var oldestName string
+		var oldest *File
+		for _, files := range s.streams {
+			for name, f := range files {
+				if !isSegment(name) {
+					continue
+				}
+				if oldest == nil || f.seq < oldest.seq {
+					oldestFiles, oldestName, oldest = files, name, f
+				}
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		s.remove(oldestFiles, oldestName)
+		s.trimPlaylists(oldestFiles, path.Dir(oldestName))
+		metrics.SegmentStoreEvictions.WithLabelValues("space").Inc()
+	}
+}
+
+// trimPlaylists removes the segments missing from the store from the
+// playlists in dir.
+func (s *Store) trimPlaylists(files map[string]*File, dir string) {
+	for name, f := range files {
+		if !isPlaylist(name) || path.Dir(name) != dir {
+			continue
+		}
+		trimmed := trimMissing(files, dir, f.Data)
+		s.size += int64(len(trimmed) - len(f.Data))
+		f.Data = trimmed
+	}
+}
+
+// trimMissing removes the leading media segments of a playlist in dir that
+// are not in files. Segments are evicted oldest first, so only the head of
+// the playlist can be missing.
+func trimMissing(files map[string]*File, dir string, playlist []byte) []byte {
+	return hls.TrimSegments(playlist, func(uri string) bool {
+		if strings.Contains(uri, "://") {
+			return true
+		}
+		uri, _, _ = strings.Cut(uri, "?")
+		_, ok := files[path.Join(dir, uri)]
+		return ok
+	})
+}
+
+// listedFiles returns the paths, relative to the stream, of the files an
+// HLS playlist in dir refers to, mapped to true for media segments and to
+// false for files referenced from tags such as EXT-X-MAP init segments.
+func listedFiles(dir string, playlist []byte) map[string]bool {
+	listed := make(map[string]bool)
+	add := func(uri string, media bool) {
+		if uri == "" || strings.Contains(uri, "://") {
+			return
+		}
+		uri, _, _ = strings.Cut(uri, "?")
+		listed[path.Join(dir, uri)] = media
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			for _, m := range hls.URIAttribute.FindAllStringSubmatch(line, -1) {
+				add(m[1], false)
+			}
+		default:
+			add(line, true)
+		}
+	}
+	return listed
+}
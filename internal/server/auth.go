@@ -0,0 +1,143 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/abaddouh/poll-streamer/internal/auth"
+	"github.com/abaddouh/poll-streamer/internal/registry"
+)
+
+// defaultTokenTTL is the lifetime of tokens issued without an explicit ttl.
+const defaultTokenTTL = time.Hour
+
+// requiredScope returns the scope needed to call the route matched by
+// pattern, or "" if the route is public. Routes not listed here require the
+// admin scope.
+func requiredScope(method, pattern string) auth.Scope {
+	switch pattern {
+	case "/", "/heartbeat":
+		return ""
+	case ingestPrefix:
+		// FFmpeg authenticates uploads with the segment store's token.
+		return ""
+	case "/placeholder":
+		if method == http.MethodGet {
+			return ""
+		}
+		return auth.ScopeAdmin
+	case "/stream/",
+		"GET /recordings/{id}/exports/{export}/{file}",
+		"GET /streams/{id}/mjpeg",
+		"GET /streams/{id}/snapshot.jpg",
+		"GET /streams/{id}/snapshot.png":
+		return auth.ScopeViewer
+	case "/generate-stream",
+		"POST /streams/{id}/signed-url",
+		"GET /streams/{id}",
+		"DELETE /streams/{id}",
+		"POST /streams/{id}/frames",
+		"PUT /streams/{id}/sources",
+		"DELETE /streams/{id}/sources",
+		"GET /streams/{id}/encoder",
+		"GET /recordings/{id}",
+		"POST /recordings/{id}/exports",
+		"GET /recordings/{id}/exports/{export}":
+		return auth.ScopeProducer
+	default:
+		return auth.ScopeAdmin
+	}
+}
+
+// authorize rejects requests to mux that lack credentials granting the
+// scope required by the matched route. It is a no-op when no credentials
+// are configured.
+func (s *Server) authorize(mux *http.ServeMux) http.Handler {
+	if s.auth == nil || !s.auth.Enabled() {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		scope := requiredScope(r.Method, pattern)
+		// Requests carrying a signed stream token are checked by
+		// streamHandler instead.
+		if pattern == "/stream/" && r.URL.Query().Has(streamTokenParam) {
+			scope = ""
+		}
+		if scope == "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := s.auth.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="poll-streamer"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !principal.Has(scope) {
+			http.Error(w, fmt.Sprintf("Forbidden: %s scope required", scope), http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// issueTokenHandler signs a bearer token for the requested subject and
+// scopes.
+func (s *Server) issueTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Subject string             `json:"subject"`
+		Scopes  []string           `json:"scopes"`
+		TTL     *registry.Duration `json:"ttl"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("invalid JSON body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.Subject == "" || len(req.Scopes) == 0 {
+		http.Error(w, "subject and scopes are required", http.StatusBadRequest)
+		return
+	}
+	scopes := make([]auth.Scope, 0, len(req.Scopes))
+	for _, name := range req.Scopes {
+		scope, err := auth.ParseScope(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+	ttl := defaultTokenTTL
+	if req.TTL != nil {
+		ttl = time.Duration(*req.TTL)
+	}
+	if ttl <= 0 {
+		http.Error(w, "ttl must be positive", http.StatusBadRequest)
+		return
+	}
+
+	if s.auth == nil {
+		http.Error(w, "Token signing is not configured", http.StatusNotImplemented)
+		return
+	}
+	token, expiresAt, err := s.auth.IssueToken(req.Subject, scopes, ttl)
+	if err != nil {
+		log.Printf("Error issuing token for %s: %v", req.Subject, err)
+		http.Error(w, "Token signing is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"token":      token,
+		"expires_at": expiresAt,
+	})
+}
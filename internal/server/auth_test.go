@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/abaddouh/poll-streamer/internal/auth"
+)
+
+// routeTests holds a request for every registered route, the pattern it
+// must match and the scope that pattern requires.
+var routeTests = []struct {
+	method  string
+	path    string
+	pattern string
+	scope   auth.Scope
+}{
+	{"GET", "/", "/", ""},
+	{"GET", "/heartbeat", "/heartbeat", ""},
+	{"PUT", "/internal/segments/abc/segment_000001.ts", ingestPrefix, ""},
+	{"GET", "/placeholder", "/placeholder", ""},
+	{"POST", "/placeholder", "/placeholder", auth.ScopeAdmin},
+	{"GET", "/stream/abc/playlist.m3u8", "/stream/", auth.ScopeViewer},
+	{"GET", "/streams/abc/mjpeg", "GET /streams/{id}/mjpeg", auth.ScopeViewer},
+	{"GET", "/streams/abc/snapshot.jpg", "GET /streams/{id}/snapshot.jpg", auth.ScopeViewer},
+	{"GET", "/streams/abc/snapshot.png", "GET /streams/{id}/snapshot.png", auth.ScopeViewer},
+	{"GET", "/recordings/abc/exports/def/video.mp4", "GET /recordings/{id}/exports/{export}/{file}", auth.ScopeViewer},
+	{"POST", "/generate-stream", "/generate-stream", auth.ScopeProducer},
+	{"GET", "/streams/abc", "GET /streams/{id}", auth.ScopeProducer},
+	{"DELETE", "/streams/abc", "DELETE /streams/{id}", auth.ScopeProducer},
+	{"POST", "/streams/abc/frames", "POST /streams/{id}/frames", auth.ScopeProducer},
+	{"PUT", "/streams/abc/sources", "PUT /streams/{id}/sources", auth.ScopeProducer},
+	{"DELETE", "/streams/abc/sources", "DELETE /streams/{id}/sources", auth.ScopeProducer},
+	{"GET", "/streams/abc/encoder", "GET /streams/{id}/encoder", auth.ScopeProducer},
+	{"POST", "/streams/abc/signed-url", "POST /streams/{id}/signed-url", auth.ScopeProducer},
+	{"GET", "/recordings/abc", "GET /recordings/{id}", auth.ScopeProducer},
+	{"POST", "/recordings/abc/exports", "POST /recordings/{id}/exports", auth.ScopeProducer},
+	{"GET", "/recordings/abc/exports/def", "GET /recordings/{id}/exports/{export}", auth.ScopeProducer},
+	{"POST", "/shutdown", "/shutdown", auth.ScopeAdmin},
+	{"GET", "/streams", "GET /streams", auth.ScopeAdmin},
+	{"POST", "/tokens", "POST /tokens", auth.ScopeAdmin},
+	{"GET", "/recordings", "GET /recordings", auth.ScopeAdmin},
+	{"DELETE", "/recordings/abc", "DELETE /recordings/{id}", auth.ScopeAdmin},
+	{"GET", "/metrics", "GET /metrics", auth.ScopeAdmin},
+}
+
+func TestRequiredScope(t *testing.T) {
+	mux := (&Server{}).routes()
+	for _, tt := range routeTests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if _, pattern := mux.Handler(r); pattern != tt.pattern {
+			t.Errorf("%s %s matched %q, want %q", tt.method, tt.path, pattern, tt.pattern)
+			continue
+		}
+		if got := requiredScope(tt.method, tt.pattern); got != tt.scope {
+			t.Errorf("requiredScope(%s, %q) = %q, want %q", tt.method, tt.pattern, got, tt.scope)
+		}
+	}
+
+	// Routes missing from requiredScope fall back to admin.
+	for _, pattern := range []string{"GET /streams/{id}/debug", "/unknown", ""} {
+		if got := requiredScope("GET", pattern); got != auth.ScopeAdmin {
+			t.Errorf("requiredScope(GET, %q) = %q, want admin", pattern, got)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	a := auth.New(map[string][]auth.Scope{
+		"admin-key":    {auth.ScopeAdmin},
+		"producer-key": {auth.ScopeProducer},
+		"viewer-key":   {auth.ScopeViewer},
+	}, "token-secret")
+	s := &Server{auth: a}
+	handler := s.authorize(s.routes())
+
+	token, _, err := a.IssueToken("dashboard", []auth.Scope{auth.ScopeViewer}, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+	expired, _, err := a.IssueToken("dashboard", []auth.Scope{auth.ScopeAdmin}, -time.Minute)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		value  string
+		want   int
+	}{
+		{"public route", "GET", "/heartbeat", "", "", http.StatusOK},
+		{"no credentials", "GET", "/metrics", "", "", http.StatusUnauthorized},
+		{"unknown key", "GET", "/metrics", "X-API-Key", "other-key", http.StatusUnauthorized},
+		{"expired token", "GET", "/metrics", "Authorization", "Bearer " + expired, http.StatusUnauthorized},
+		{"viewer on admin route", "GET", "/metrics", "X-API-Key", "viewer-key", http.StatusForbidden},
+		{"producer on admin route", "GET", "/metrics", "X-API-Key", "producer-key", http.StatusForbidden},
+		{"viewer token on admin route", "GET", "/metrics", "Authorization", "Bearer " + token, http.StatusForbidden},
+		{"viewer on producer route", "DELETE", "/streams/abc", "X-API-Key", "viewer-key", http.StatusForbidden},
+		{"admin", "GET", "/metrics", "X-API-Key", "admin-key", http.StatusOK},
+		{"forged stream token", "GET", "/stream/abc/playlist.m3u8?token=1.forged", "", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("%s %s returned %d, want %d", tt.method, tt.path, w.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 response lacks a WWW-Authenticate header")
+			}
+		})
+	}
+}
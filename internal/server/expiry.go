@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/abaddouh/poll-streamer/internal/metrics"
+	"github.com/abaddouh/poll-streamer/internal/registry"
+)
+
+// reapInterval is how often the reaper looks for expired streams.
+const reapInterval = 10 * time.Second
+
+const (
+	expiredTTL  = "ttl"
+	expiredIdle = "idle"
+)
+
+// ExpiryEvent is emitted when the reaper stops a stream.
+type ExpiryEvent struct {
+	StreamID  string    `json:"stream_id"`
+	Reason    string    `json:"reason"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+// lifetime tracks when a stream becomes eligible for expiry.
+type lifetime struct {
+	createdAt   time.Time
+	ttl         time.Duration
+	idleTimeout time.Duration
+	// lastViewed is the time of the last viewer request, or the time the
+	// stream was started or restored if it has not been viewed since.
+	lastViewed time.Time
+}
+
+// track starts tracking the lifetime of a registered stream.
+func (s *Server) track(rec registry.Record) {
+	s.mu.Lock()
+	s.lifetimes[rec.ID] = &lifetime{
+		createdAt:   rec.CreatedAt,
+		ttl:         time.Duration(rec.TTL),
+		idleTimeout: time.Duration(rec.IdleTimeout),
+		lastViewed:  time.Now(),
+	}
+	s.mu.Unlock()
+}
+
+// touch records a viewer request for a stream.
+func (s *Server) touch(streamID string) {
+	s.mu.Lock()
+	if lt, exists := s.lifetimes[streamID]; exists {
+		lt.lastViewed = time.Now()
+	}
+	s.mu.Unlock()
+}
+
+// RunReaper periodically stops streams that have outlived their TTL or have
+// been idle for longer than their idle timeout, until ctx is cancelled.
+func (s *Server) RunReaper(ctx context.Context) {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.reap()
+		}
+	}
+}
+
+// reap stops every stream that has expired.
+func (s *Server) reap() {
+	now := time.Now()
+
+	s.mu.RLock()
+	expired := make(map[string]string)
+	for id, lt := range s.lifetimes {
+		if reason := s.expiryReason(id, lt, now); reason != "" {
+			expired[id] = reason
+		}
+	}
+	s.mu.RUnlock()
+
+	for id, reason := range expired {
+		if err := s.removeStream(id); err != nil {
+			log.Printf("Error expiring stream %s: %v", id, err)
+			continue
+		}
+		metrics.StreamsExpired.WithLabelValues(reason).Inc()
+		log.Printf("Stream %s expired (%s)", id, reason)
+		if s.onExpire != nil {
+			s.onExpire(ExpiryEvent{StreamID: id, Reason: reason, ExpiredAt: time.Now()})
+		}
+	}
+}
+
+// expiryReason reports why a stream has expired, or "" if it has not. The
+// stream counts as active while it receives frames or viewer requests.
+func (s *Server) expiryReason(streamID string, lt *lifetime, now time.Time) string {
+	if lt.ttl > 0 && now.Sub(lt.createdAt) >= lt.ttl {
+		return expiredTTL
+	}
+	if lt.idleTimeout <= 0 {
+		return ""
+	}
+
+	lastActive := lt.lastViewed
+	if info, err := s.streamer.StreamInfo(streamID); err == nil && info.LastFrameAt != nil && info.LastFrameAt.After(lastActive) {
+		lastActive = *info.LastFrameAt
+	}
+	if now.Sub(lastActive) >= lt.idleTimeout {
+		return expiredIdle
+	}
+	return ""
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/abaddouh/poll-streamer/internal/metrics"
+	"github.com/abaddouh/poll-streamer/internal/watcher"
+)
+
+const (
+	// maxFrameSize bounds the size of a single uploaded image.
+	maxFrameSize = 10 << 20
+	// maxUploadSize bounds the size of a whole upload request.
+	maxUploadSize = 5 * maxFrameSize
+)
+
+// frameFormats maps accepted upload content types to the image format
+// reported by image.DecodeConfig.
+var frameFormats = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+}
+
+// uploadFrameHandler accepts images for a stream, either as a raw
+// image/jpeg or image/png body or as one or more multipart file parts, and
+// enqueues them on the job pipeline.
+func (s *Server) uploadFrameHandler(w http.ResponseWriter, r *http.Request) {
+	streamID := r.PathValue("id")
+	if _, exists := s.GetStreamPath(streamID); !exists {
+		http.Error(w, "Stream not found", http.StatusNotFound)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, "Missing or invalid Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var frames [][]byte
+	if mediaType == "multipart/form-data" {
+		frames, err = readMultipartFrames(multipart.NewReader(r.Body, params["boundary"]))
+	} else {
+		var frame []byte
+		frame, err = readFrame(r.Body, mediaType)
+		frames = append(frames, frame)
+	}
+	if err != nil {
+		var unsupported *unsupportedTypeError
+		if errors.As(err, &unsupported) {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(frames) == 0 {
+		http.Error(w, "No image found in request", http.StatusBadRequest)
+		return
+	}
+
+	// Reject the whole upload up front if the queue cannot take it, so a
+	// full queue does not leave it half enqueued.
+	if cap(s.jobs)-len(s.jobs) < len(frames) {
+		metrics.JobsDropped.WithLabelValues("upload").Add(float64(len(frames)))
+		http.Error(w, "Frame queue is full, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	for i, frame := range frames {
+		select {
+		case s.jobs <- watcher.WatcherJob{StreamID: streamID, Data: frame}:
+			metrics.JobsEnqueued.WithLabelValues("upload").Inc()
+			log.Printf("Job enqueued: StreamID=%s, upload of %d bytes", streamID, len(frame))
+		default:
+			// Other sources filled the queue in the meantime. Report what
+			// landed, so the client only retries the remaining frames.
+			metrics.JobsDropped.WithLabelValues("upload").Add(float64(len(frames) - i))
+			writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+				"error":    "Frame queue is full, try again later",
+				"accepted": i,
+			})
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]int{"accepted": len(frames)})
+}
+
+// unsupportedTypeError reports an upload whose content type is not an
+// accepted image format.
+type unsupportedTypeError struct {
+	contentType string
+}
+
+func (e *unsupportedTypeError) Error() string {
+	return fmt.Sprintf("unsupported content type %q, expected image/jpeg or image/png", e.contentType)
+}
+
+// readMultipartFrames reads every file part of a multipart upload.
+func readMultipartFrames(mr *multipart.Reader) ([][]byte, error) {
+	var frames [][]byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return frames, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart body: %v", err)
+		}
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			part.Close()
+			return nil, &unsupportedTypeError{contentType: part.Header.Get("Content-Type")}
+		}
+		frame, err := readFrame(part, mediaType)
+		part.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", part.FileName(), err)
+		}
+		frames = append(frames, frame)
+	}
+}
+
+// readFrame reads a single image and checks that it decodes as the declared
+// format.
+func readFrame(r io.Reader, mediaType string) ([]byte, error) {
+	format, ok := frameFormats[mediaType]
+	if !ok {
+		return nil, &unsupportedTypeError{contentType: mediaType}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, maxFrameSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading image: %v", err)
+	}
+	if len(data) > maxFrameSize {
+		return nil, fmt.Errorf("image exceeds the %d byte limit", maxFrameSize)
+	}
+
+	config, decoded, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %v", err)
+	}
+	if decoded != format {
+		return nil, fmt.Errorf("image is %s but was sent as %s", decoded, mediaType)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, fmt.Errorf("image has invalid dimensions %dx%d", config.Width, config.Height)
+	}
+	return data, nil
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/abaddouh/poll-streamer/internal/segstore"
+)
+
+const (
+	// ingestPrefix is the route FFmpeg uploads the output of in-memory
+	// streams to, as /internal/segments/{stream_id}/{file}.
+	ingestPrefix = "/internal/segments/"
+	// maxIngestBytes bounds the size of a single uploaded file.
+	maxIngestBytes = 64 << 20
+)
+
+// ingestHandler stores the playlists and segments FFmpeg uploads with PUT,
+// removes those it deletes and serves them back, which the HLS muxer does
+// when appending to an existing playlist. Only local requests carrying the
+// store's token are accepted.
+func (s *Server) ingestHandler(w http.ResponseWriter, r *http.Request) {
+	if s.segments == nil {
+		http.NotFound(w, r)
+		return
+	}
+	token := r.Header.Get(segstore.TokenHeader)
+	if !fromLoopback(r) || subtle.ConstantTimeCompare([]byte(token), []byte(s.segments.Token())) != 1 {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	streamID, name, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, ingestPrefix), "/")
+	if !ok || streamID == "" || name == "" || path.Clean(name) != name || strings.HasPrefix(name, "..") {
+		http.Error(w, "Invalid file name", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIngestBytes))
+		if err != nil {
+			log.Printf("Error receiving %s for stream %s: %v", name, streamID, err)
+			http.Error(w, "Failed to read upload", http.StatusBadRequest)
+			return
+		}
+		if !s.segments.Put(streamID, name, data) {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		s.segments.Delete(streamID, name)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet, http.MethodHead:
+		f, ok := s.segments.Get(streamID, name)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, name, f.ModTime, bytes.NewReader(f.Data))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// fromLoopback reports whether a request was made from the local host.
+func fromLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// serveFromStore serves a playlist or segment held in the in-memory segment
+// store. It reports whether the file was found; anything else, such as the
+// master playlist of a ladder, is served from disk.
+func (s *Server) serveFromStore(w http.ResponseWriter, r *http.Request, streamID, fileName, token string) bool {
+	if s.segments == nil {
+		return false
+	}
+	f, ok := s.segments.Get(streamID, fileName)
+	if !ok {
+		return false
+	}
+	s.touch(streamID)
+
+	setContentType(w, fileName)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if strings.HasSuffix(fileName, ".m3u8") {
+		playlist := f.Data
+		if token != "" {
+			playlist = signPlaylist(playlist, token)
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(playlist)
+		return true
+	}
+	http.ServeContent(w, r, fileName, f.ModTime, bytes.NewReader(f.Data))
+	return true
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/abaddouh/poll-streamer/internal/streamer"
+)
+
+// serveLowLatency serves the dynamic resources of an LL-HLS stream: the
+// media playlist with blocking reload, full segments assembled from their
+// parts and parts that are still being written. It reports whether the
+// request was handled; other files are served from disk as usual.
+func (s *Server) serveLowLatency(w http.ResponseWriter, r *http.Request, streamID, fileName, token string) bool {
+	var err error
+	switch {
+	case fileName == "stream.m3u8":
+		err = s.serveLowLatencyPlaylist(w, r, streamID, token)
+	case strings.HasPrefix(fileName, "segment") && strings.HasSuffix(fileName, ".m4s"):
+		err = s.serveLowLatencySegment(w, r, streamID, fileName)
+	case strings.HasPrefix(fileName, "part") && strings.HasSuffix(fileName, ".m4s"):
+		var path string
+		path, err = s.streamer.LowLatencyPart(r.Context(), streamID, fileName)
+		if err == nil {
+			s.touch(streamID)
+			w.Header().Set("Content-Type", "video/iso.segment")
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			http.ServeFile(w, r, path)
+		}
+	default:
+		return false
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, streamer.ErrNotLowLatency):
+		return false
+	case errors.Is(err, streamer.ErrStreamNotFound), errors.Is(err, os.ErrNotExist):
+		http.NotFound(w, r)
+	case errors.Is(err, streamer.ErrTooFarAhead), errors.Is(err, streamer.ErrPartOutOfRange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, streamer.ErrPlaylistTimeout):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case r.Context().Err() != nil:
+		// The client went away while blocked.
+	default:
+		log.Printf("Error serving %s for stream %s: %v", fileName, streamID, err)
+		http.Error(w, "Failed to serve stream", http.StatusInternalServerError)
+	}
+	return true
+}
+
+// serveLowLatencyPlaylist serves the LL-HLS media playlist, holding the
+// request until the segment or part named by the _HLS_msn and _HLS_part
+// delivery directives is available. Other streams ignore the directives.
+func (s *Server) serveLowLatencyPlaylist(w http.ResponseWriter, r *http.Request, streamID, token string) error {
+	if !s.streamer.LowLatency(streamID) {
+		return streamer.ErrNotLowLatency
+	}
+
+	msn, part := -1, -1
+	query := r.URL.Query()
+	if v := query.Get("_HLS_msn"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid _HLS_msn", http.StatusBadRequest)
+			return nil
+		}
+		msn = n
+	}
+	if v := query.Get("_HLS_part"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || msn < 0 {
+			http.Error(w, "invalid _HLS_part", http.StatusBadRequest)
+			return nil
+		}
+		part = n
+	}
+
+	playlist, err := s.streamer.LowLatencyPlaylist(r.Context(), streamID, msn, part)
+	if err != nil {
+		return err
+	}
+	s.touch(streamID)
+
+	if token != "" {
+		playlist = signPlaylist(playlist, token)
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(playlist)
+	return nil
+}
+
+// serveLowLatencySegment serves a full segment by concatenating its parts.
+func (s *Server) serveLowLatencySegment(w http.ResponseWriter, r *http.Request, streamID, fileName string) error {
+	var msn int
+	if _, err := fmt.Sscanf(fileName, "segment%d.m4s", &msn); err != nil {
+		return os.ErrNotExist
+	}
+	files, err := s.streamer.LowLatencySegment(streamID, msn)
+	if err != nil {
+		return err
+	}
+
+	// Open every part up front so a part deleted meanwhile fails the request
+	// before anything is written.
+	parts := make([]io.Reader, 0, len(files))
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		parts = append(parts, f)
+	}
+	s.touch(streamID)
+
+	w.Header().Set("Content-Type", "video/iso.segment")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if _, err := io.Copy(w, io.MultiReader(parts...)); err != nil {
+		log.Printf("Error writing segment %s for stream %s: %v", fileName, streamID, err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/abaddouh/poll-streamer/internal/streamer"
+)
+
+const (
+	// mjpegBoundary separates the frames of an MJPEG response.
+	mjpegBoundary = "frame"
+	// mjpegKeepAlive is how often the current frame is sent again while the
+	// stream holds still, so proxies do not drop the idle connection.
+	mjpegKeepAlive = 10 * time.Second
+	// mjpegWriteTimeout bounds the write of a single frame; viewers stalled
+	// for longer are disconnected.
+	mjpegWriteTimeout = 10 * time.Second
+)
+
+// mjpegHandler serves the frames of a stream as multipart/x-mixed-replace
+// MJPEG, straight from the frames the workers feed the stream without going
+// through FFmpeg.
+func (s *Server) mjpegHandler(w http.ResponseWriter, r *http.Request) {
+	streamID := r.PathValue("id")
+	frames, cancel, err := s.streamer.SubscribeFrames(streamID)
+	if errors.Is(err, streamer.ErrStreamNotFound) {
+		http.Error(w, "Stream not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error subscribing to frames of stream %s: %v", streamID, err)
+		http.Error(w, "Failed to serve stream", http.StatusInternalServerError)
+		return
+	}
+	defer cancel()
+
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(mjpegBoundary); err != nil {
+		http.Error(w, "Failed to serve stream", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	rc := http.NewResponseController(w)
+
+	keepAlive := time.NewTicker(mjpegKeepAlive)
+	defer keepAlive.Stop()
+
+	var frame []byte
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case next, ok := <-frames:
+			if !ok {
+				// The stream was stopped.
+				mw.Close()
+				return
+			}
+			frame = next
+			keepAlive.Reset(mjpegKeepAlive)
+		case <-keepAlive.C:
+			if frame == nil {
+				continue
+			}
+		}
+
+		s.touch(streamID)
+		rc.SetWriteDeadline(time.Now().Add(mjpegWriteTimeout))
+		if err := writeMJPEGFrame(mw, frame); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeMJPEGFrame writes one JPEG as a part of an MJPEG response.
+func writeMJPEGFrame(mw *multipart.Writer, frame []byte) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":   {"image/jpeg"},
+		"Content-Length": {strconv.Itoa(len(frame))},
+	})
+	if err != nil {
+		return fmt.Errorf("error starting frame: %v", err)
+	}
+	if _, err := part.Write(frame); err != nil {
+		return fmt.Errorf("error writing frame: %v", err)
+	}
+	return nil
+}
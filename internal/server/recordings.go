@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"github.com/abaddouh/poll-streamer/internal/recorder"
+)
+
+// recordingsEnabled rejects requests when recording is not configured.
+func (s *Server) recordingsEnabled(w http.ResponseWriter) bool {
+	if s.recorder == nil {
+		http.Error(w, "Recording is not configured", http.StatusNotImplemented)
+		return false
+	}
+	return true
+}
+
+// exportURL returns the URL of a file produced by an export.
+func (s *Server) exportURL(r *http.Request, export recorder.Export) string {
+	return s.publicURL(r, fmt.Sprintf("/recordings/%s/exports/%s/%s", export.StreamID, export.ID, export.File))
+}
+
+// exportResponse adds the download URL to finished exports.
+func (s *Server) exportResponse(r *http.Request, export recorder.Export) map[string]interface{} {
+	response := map[string]interface{}{"export": export}
+	if export.State == recorder.StateDone {
+		response["url"] = s.exportURL(r, export)
+	}
+	return response
+}
+
+// listRecordingsHandler lists the streams that have a recording, including
+// streams that have since been deleted.
+func (s *Server) listRecordingsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.recordingsEnabled(w) {
+		return
+	}
+	ids, err := s.recorder.List()
+	if err != nil {
+		log.Printf("Error listing recordings: %v", err)
+		http.Error(w, "Failed to list recordings", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, ids)
+}
+
+// getRecordingHandler describes the recording of a stream and its exports.
+func (s *Server) getRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.recordingsEnabled(w) {
+		return
+	}
+	rec, err := s.recorder.Recording(r.PathValue("id"))
+	if errors.Is(err, recorder.ErrNotFound) {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading recording %s: %v", r.PathValue("id"), err)
+		http.Error(w, "Failed to read recording", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// deleteRecordingHandler removes the recording of a stream and its exports.
+func (s *Server) deleteRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.recordingsEnabled(w) {
+		return
+	}
+	err := s.recorder.Delete(r.PathValue("id"))
+	if errors.Is(err, recorder.ErrNotFound) {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting recording %s: %v", r.PathValue("id"), err)
+		http.Error(w, "Failed to delete recording", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createExportHandler starts rendering a time range of a recording into an
+// MP4 or an HLS VOD playlist.
+func (s *Server) createExportHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.recordingsEnabled(w) {
+		return
+	}
+	var req recorder.ExportRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("invalid JSON body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	export, err := s.recorder.StartExport(r.PathValue("id"), req)
+	if errors.Is(err, recorder.ErrNotFound) {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, recorder.ErrBusy) {
+		http.Error(w, "Too many exports pending, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Started export %s of stream %s (%d frames)", export.ID, export.StreamID, export.Frames)
+
+	response := s.exportResponse(r, export)
+	response["status_url"] = s.publicURL(r, fmt.Sprintf("/recordings/%s/exports/%s", export.StreamID, export.ID))
+	writeJSON(w, http.StatusAccepted, response)
+}
+
+// getExportHandler reports the state of an export.
+func (s *Server) getExportHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.recordingsEnabled(w) {
+		return
+	}
+	export, err := s.recorder.Export(r.PathValue("id"), r.PathValue("export"))
+	if errors.Is(err, recorder.ErrNotFound) {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading export %s: %v", r.PathValue("export"), err)
+		http.Error(w, "Failed to read export", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.exportResponse(r, export))
+}
+
+// exportFileHandler serves the files of a finished export.
+func (s *Server) exportFileHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.recordingsEnabled(w) {
+		return
+	}
+	path, err := s.recorder.ExportFile(r.PathValue("id"), r.PathValue("export"), r.PathValue("file"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch filepath.Ext(path) {
+	case ".m3u8":
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	case ".ts":
+		w.Header().Set("Content-Type", "video/MP2T")
+	case ".mp4":
+		w.Header().Set("Content-Type", "video/mp4")
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.ServeFile(w, r, path)
+}
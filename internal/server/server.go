@@ -3,61 +3,200 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/jpeg"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"strconv"
 
+	"github.com/abaddouh/poll-streamer/internal/auth"
+	"github.com/abaddouh/poll-streamer/internal/metrics"
+	"github.com/abaddouh/poll-streamer/internal/recorder"
+	"github.com/abaddouh/poll-streamer/internal/registry"
+	"github.com/abaddouh/poll-streamer/internal/segstore"
+	"github.com/abaddouh/poll-streamer/internal/source"
 	"github.com/abaddouh/poll-streamer/internal/streamer"
+	"github.com/abaddouh/poll-streamer/internal/watcher"
 	"github.com/google/uuid"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/basicfont"
 	"golang.org/x/image/math/fixed"
 )
 
+// Config holds the settings of a Server.
+type Config struct {
+	Port      int
+	ImagePath string
+	// TTL and IdleTimeout apply to streams created without their own. Zero
+	// disables the corresponding expiry.
+	TTL         time.Duration
+	IdleTimeout time.Duration
+	// Auth guards the API. A nil or disabled Authenticator leaves every
+	// route open.
+	Auth *auth.Authenticator
+	// Recorder serves recordings and their exports. The recording routes
+	// are unavailable if nil.
+	Recorder *recorder.Recorder
+	// Segments serves the HLS output held in memory and accepts uploads
+	// from FFmpeg. Streams are served from disk only if nil.
+	Segments *segstore.Store
+	// PublicURL is the base URL clients reach the server at, e.g.
+	// https://streams.example.com, used to build the URLs handed out by the
+	// API. URLs are built from the request's scheme and host if empty.
+	PublicURL string
+	// OnExpire is called by the reaper after it stopped an expired
+	// stream. It must not block.
+	OnExpire func(ExpiryEvent)
+	// SourceAllow lists the private ranges HTTP sources may poll. Sources
+	// may only reach public addresses otherwise.
+	SourceAllow []*net.IPNet
+}
+
 type Server struct {
 	port           int
-	outputPath     string
+	imagePath      string
 	placeholderImg string
 	srv            *http.Server
 	streams        map[string]string
+	lifetimes      map[string]*lifetime
+	ttl            time.Duration
+	idleTimeout    time.Duration
 	mu             sync.RWMutex
 	streamer       *streamer.Streamer
+	jobs           chan<- watcher.WatcherJob
+	registry       registry.Registry
+	auth           *auth.Authenticator
+	recorder       *recorder.Recorder
+	segments       *segstore.Store
+	sources        map[string][]source.Source
+	baseURL        string
+	onExpire       func(ExpiryEvent)
+	sourceAllow    []*net.IPNet
 }
 
 // New initializes a new Server instance with a Streamer
-func New(port int, streamerInstance *streamer.Streamer) *Server {
+func New(cfg Config, streamerInstance *streamer.Streamer, jobs chan<- watcher.WatcherJob, reg registry.Registry) *Server {
 	return &Server{
-		port:           port,
+		port:           cfg.Port,
+		imagePath:      cfg.ImagePath,
 		placeholderImg: "placeholder.jpg",
 		streams:        make(map[string]string),
+		lifetimes:      make(map[string]*lifetime),
+		ttl:            cfg.TTL,
+		idleTimeout:    cfg.IdleTimeout,
 		streamer:       streamerInstance, // Initialize the Streamer field
+		jobs:           jobs,
+		registry:       reg,
+		auth:           cfg.Auth,
+		recorder:       cfg.Recorder,
+		segments:       cfg.Segments,
+		sources:        make(map[string][]source.Source),
+		baseURL:        strings.TrimRight(cfg.PublicURL, "/"),
+		onExpire:       cfg.OnExpire,
+		sourceAllow:    cfg.SourceAllow,
 	}
 }
 
-// Start begins the HTTP server and handles graceful shutdown.
-func (s *Server) Start(ctx context.Context) error {
+// Restore restarts the encoders of every stream recorded in the registry.
+// Streams whose parameters no longer fit the configured limits are dropped.
+func (s *Server) Restore() error {
+	records, err := s.registry.List()
+	if err != nil {
+		return fmt.Errorf("error listing registered streams: %v", err)
+	}
+
+	for _, rec := range records {
+		params, err := s.streamer.ResolveParams(rec.Params)
+		if err != nil {
+			log.Printf("Dropping stream %s, its parameters are no longer valid: %v", rec.ID, err)
+			if err := s.registry.Delete(rec.ID); err != nil {
+				log.Printf("Error removing stream %s from registry: %v", rec.ID, err)
+			}
+			continue
+		}
+
+		if err := s.streamer.ResumeStream(rec.ID, params, rec.CreatedAt); err != nil {
+			// Dropping the record keeps the stream from failing again on
+			// every boot, and its URLs from looking valid.
+			log.Printf("Dropping stream %s, it could not be restored: %v", rec.ID, err)
+			if err := s.registry.Delete(rec.ID); err != nil {
+				log.Printf("Error removing stream %s from registry: %v", rec.ID, err)
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		s.streams[rec.ID] = s.streamer.StreamDir(rec.ID)
+		s.mu.Unlock()
+		s.track(rec)
+		if len(rec.Sources) > 0 {
+			_, configs, err := s.resolveSources(rec.ID, rec.Sources)
+			if err == nil {
+				err = s.createSourceDirs(rec.ID, configs)
+			}
+			if err != nil {
+				log.Printf("Not starting the sources of stream %s, they are no longer valid: %v", rec.ID, err)
+			} else {
+				s.startSources(rec.ID, configs)
+			}
+		}
+		log.Printf("Restored stream %s", rec.ID)
+	}
+	return nil
+}
+
+// routes registers the API's handlers on a new mux.
+func (s *Server) routes() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/stream/", s.streamHandler)
 	mux.HandleFunc("/shutdown", s.shutdownHandler)
 	mux.HandleFunc("/generate-stream", s.generateStreamHandler)
+	mux.HandleFunc("GET /streams", s.listStreamsHandler)
+	mux.HandleFunc("GET /streams/{id}", s.getStreamHandler)
+	mux.HandleFunc("DELETE /streams/{id}", s.deleteStreamHandler)
+	mux.HandleFunc("POST /streams/{id}/frames", s.uploadFrameHandler)
+	mux.HandleFunc("PUT /streams/{id}/sources", s.putSourcesHandler)
+	mux.HandleFunc("DELETE /streams/{id}/sources", s.deleteSourcesHandler)
+	mux.HandleFunc("GET /streams/{id}/encoder", s.encoderHandler)
+	mux.HandleFunc("GET /streams/{id}/mjpeg", s.mjpegHandler)
+	mux.HandleFunc("GET /streams/{id}/snapshot.jpg", s.snapshotHandler)
+	mux.HandleFunc("GET /streams/{id}/snapshot.png", s.snapshotHandler)
+	mux.HandleFunc("POST /streams/{id}/signed-url", s.signedURLHandler)
+	mux.HandleFunc("POST /tokens", s.issueTokenHandler)
+	mux.HandleFunc("GET /recordings", s.listRecordingsHandler)
+	mux.HandleFunc("GET /recordings/{id}", s.getRecordingHandler)
+	mux.HandleFunc("DELETE /recordings/{id}", s.deleteRecordingHandler)
+	mux.HandleFunc("POST /recordings/{id}/exports", s.createExportHandler)
+	mux.HandleFunc("GET /recordings/{id}/exports/{export}", s.getExportHandler)
+	mux.HandleFunc("GET /recordings/{id}/exports/{export}/{file}", s.exportFileHandler)
+	mux.HandleFunc(ingestPrefix, s.ingestHandler)
+	mux.Handle("GET /metrics", metrics.Handler())
 	mux.HandleFunc("/heartbeat", s.heartbeatHandler)
 	mux.HandleFunc("/", s.homeHandler)
 	mux.HandleFunc("/placeholder", s.placeholderHandler)
+	return mux
+}
 
+// Start begins the HTTP server and handles graceful shutdown.
+func (s *Server) Start(ctx context.Context) error {
+	mux := s.routes()
 	s.srv = &http.Server{
 		Addr:    fmt.Sprintf(":%d", s.port),
-		Handler: mux,
+		Handler: instrument(mux, s.authorize(mux)),
 	}
 
 	log.Printf("Starting HTTP server on port %d...\n", s.port)
@@ -65,6 +204,7 @@ func (s *Server) Start(ctx context.Context) error {
 	go func() {
 		<-ctx.Done()
 		log.Println("Server is shutting down...")
+		s.stopAllSources()
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		if err := s.srv.Shutdown(shutdownCtx); err != nil {
@@ -92,6 +232,24 @@ func (s *Server) homeHandler(w http.ResponseWriter, r *http.Request) {
 Available Routes:
 - GET /heartbeat: Check if the server is running.
 - POST /generate-stream: Generate a new stream.
+- GET /streams: List all streams.
+- GET /streams/{stream_id}: Inspect a stream.
+- DELETE /streams/{stream_id}: Stop and remove a stream.
+- POST /streams/{stream_id}/frames: Push a JPEG or PNG image to a stream.
+- PUT /streams/{stream_id}/sources: Bind a stream to the sources it polls or watches.
+- DELETE /streams/{stream_id}/sources: Stop and unbind the sources of a stream.
+- GET /streams/{stream_id}/encoder: Inspect a stream's encoder statistics and output.
+- GET /streams/{stream_id}/mjpeg: Watch a stream as MJPEG.
+- GET /streams/{stream_id}/snapshot.jpg: Fetch the current frame of a stream (also .png, ?width= to resize).
+- POST /streams/{stream_id}/signed-url: Create a signed, expiring playlist URL for a stream.
+- POST /tokens: Issue a signed bearer token (admin).
+- GET /recordings: List all recordings (admin).
+- GET /recordings/{stream_id}: Inspect the recording of a stream.
+- DELETE /recordings/{stream_id}: Delete the recording of a stream and its exports (admin).
+- POST /recordings/{stream_id}/exports: Export part of a recording as MP4 or HLS VOD.
+- GET /recordings/{stream_id}/exports/{export_id}: Check the state of an export.
+- GET /recordings/{stream_id}/exports/{export_id}/{file}: Download the files of a finished export.
+- GET /metrics: Prometheus metrics.
 - GET /stream/{stream_id}/stream.m3u8: Access a specific stream.
 - GET /placeholder: Retrieve the current placeholder image.
 - POST /placeholder: Generate a new placeholder image.
@@ -249,60 +407,198 @@ func (s *Server) generateStreamHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	requested, err := parseStreamRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params, err := s.streamer.ResolveParams(requested.EncodingParams)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if requested.SignedURLTTL != nil && (s.auth == nil || !s.auth.Enabled()) {
+		http.Error(w, "URL signing is not configured", http.StatusBadRequest)
+		return
+	}
+	if requested.SignedURLTTL != nil && params.RTMPOnly {
+		http.Error(w, "signed_url_ttl cannot be combined with rtmp_only", http.StatusBadRequest)
+		return
+	}
 	streamID := uuid.New().String()
-	streamPath := fmt.Sprintf("/stream/%s/stream.m3u8", streamID)
-	fullStreamPath := filepath.Join(s.outputPath, streamID)
+	sources, sourceConfigs, err := s.resolveSources(streamID, requested.Sources)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	streamPath := fmt.Sprintf("/stream/%s/%s", streamID, params.Playlist())
+	fullStreamPath := s.streamer.StreamDir(streamID)
+
+	// Sign before starting the stream, so a signing failure leaves nothing
+	// behind and never hands out an unsigned URL for a signed stream.
+	signed := make(map[string]interface{})
+	if requested.SignedURLTTL != nil {
+		ttl := time.Duration(*requested.SignedURLTTL)
+		signedURL, expiresAt, err := s.signedStreamURL(r, streamID, params.Playlist(), ttl)
+		if err != nil {
+			log.Printf("Error signing URL for stream %s: %v", streamID, err)
+			http.Error(w, "Failed to sign stream URL", http.StatusInternalServerError)
+			return
+		}
+		signed["signed_url"] = signedURL
+		signed["signed_url_expires_at"] = expiresAt
+		if manifest := params.Manifest(); manifest != "" {
+			signedDASHURL, _, err := s.signedStreamURL(r, streamID, manifest, ttl)
+			if err != nil {
+				log.Printf("Error signing DASH URL for stream %s: %v", streamID, err)
+				http.Error(w, "Failed to sign stream URL", http.StatusInternalServerError)
+				return
+			}
+			signed["signed_dash_url"] = signedDASHURL
+		}
+	}
+
+	if err := s.streamer.StartStream(streamID, params); err != nil {
+		log.Printf("Error starting stream %s: %v", streamID, err)
+		http.Error(w, "Failed to initialize stream", http.StatusInternalServerError)
+		return
+	}
+	// Source directories are only created for a running stream, so failed
+	// requests leave no image directory behind.
+	if err := s.createSourceDirs(streamID, sourceConfigs); err != nil {
+		log.Printf("Error creating source directories of stream %s: %v", streamID, err)
+		if err := s.streamer.StopStream(streamID); err != nil {
+			log.Printf("Error removing stream %s: %v", streamID, err)
+		}
+		if s.imagePath != "" {
+			if err := os.RemoveAll(filepath.Join(s.imagePath, streamID)); err != nil {
+				log.Printf("Error removing images of stream %s: %v", streamID, err)
+			}
+		}
+		http.Error(w, "Failed to initialize stream", http.StatusInternalServerError)
+		return
+	}
 
 	s.mu.Lock()
 	s.streams[streamID] = fullStreamPath
 	s.mu.Unlock()
 
-	// Initialize the placeholder stream using the injected Streamer instance
-	go func() {
-		s.streamer.ProcessImage(s.placeholderImg, streamID)
-	}()
-
-	// Implement a wait loop with a timeout to check for stream.m3u8
-	// timeout := time.After(20 * time.Second)
-	// ticker := time.Tick(100 * time.Millisecond)
-	// streamFilePath := filepath.Join(fullStreamPath, "stream.m3u8")
-	// streamInitialized := false
-
-	// 	for {
-	// 		select {
-	// 		case <-timeout:
-	// 			log.Printf("Timeout: stream.m3u8 not found for StreamID %s after waiting", streamID)
-	// 			http.Error(w, "Failed to initialize stream", http.StatusInternalServerError)
-	// 			return
-	// 		case <-ticker:
-	// 			if _, err := os.Stat(streamFilePath); err == nil {
-	// 				log.Printf("stream.m3u8 successfully created for StreamID %s", streamID)
-	// 				streamInitialized = true
-	// 				goto OuterLoop
-	// 			}
-	// 		}
-	// 		if streamInitialized {
-	// 			break
-	// 		}
-	// 	}
-	// OuterLoop:
+	rec := registry.Record{
+		ID:          streamID,
+		Params:      params,
+		CreatedAt:   time.Now(),
+		TTL:         registry.Duration(s.ttl),
+		IdleTimeout: registry.Duration(s.idleTimeout),
+		Sources:     sources,
+	}
+	if requested.TTL != nil {
+		rec.TTL = *requested.TTL
+	}
+	if requested.IdleTimeout != nil {
+		rec.IdleTimeout = *requested.IdleTimeout
+	}
+	if info, err := s.streamer.StreamInfo(streamID); err == nil {
+		rec.CreatedAt = info.CreatedAt
+	}
+	if err := s.registry.Put(rec); err != nil {
+		log.Printf("Error registering stream %s: %v", streamID, err)
+	}
+	s.track(rec)
+	if len(sourceConfigs) > 0 {
+		s.startSources(streamID, sourceConfigs)
+	}
 
 	log.Printf("Generated new stream with ID: %s at Path: %s", streamID, fullStreamPath)
 
-	response := map[string]string{
-		"stream_url": fmt.Sprintf("http://localhost:%d%s", s.port, streamPath),
-		"stream_id":  streamID,
+	response := map[string]interface{}{
+		"stream_id": streamID,
+		"params":    params.Redacted(),
+	}
+	if !params.RTMPOnly {
+		response["stream_url"] = s.publicURL(r, streamPath)
+	}
+	if target := params.RTMPTarget(streamID); target != "" {
+		response["rtmp_url"] = streamer.RedactRTMPURL(target)
+	}
+	if publicURL := s.streamer.PublicURL(streamID, params); publicURL != "" {
+		response["public_url"] = publicURL
+	}
+	if rec.TTL > 0 {
+		response["ttl"] = rec.TTL
+		response["expires_at"] = rec.CreatedAt.Add(time.Duration(rec.TTL))
+	}
+	if rec.IdleTimeout > 0 {
+		response["idle_timeout"] = rec.IdleTimeout
+	}
+	if manifest := params.Manifest(); manifest != "" {
+		response["dash_url"] = s.publicURL(r, fmt.Sprintf("/stream/%s/%s", streamID, manifest))
+	}
+	for key, value := range signed {
+		response[key] = value
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// streamRequest is the optional JSON body of a stream creation request.
+// Omitted fields take the server defaults.
+type streamRequest struct {
+	streamer.EncodingParams
+	TTL          *registry.Duration `json:"ttl"`
+	IdleTimeout  *registry.Duration `json:"idle_timeout"`
+	SignedURLTTL *registry.Duration `json:"signed_url_ttl"`
+	Sources      []registry.Source  `json:"sources"`
+}
+
+// parseStreamRequest decodes the optional JSON body of a stream creation
+// request. An empty body requests the server defaults.
+func parseStreamRequest(r *http.Request) (streamRequest, error) {
+	var req streamRequest
+
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil && err != io.EOF {
+		return req, fmt.Errorf("invalid JSON body: %v", err)
+	}
+	if req.TTL != nil && *req.TTL < 0 {
+		return req, fmt.Errorf("ttl must not be negative")
+	}
+	if req.IdleTimeout != nil && *req.IdleTimeout < 0 {
+		return req, fmt.Errorf("idle_timeout must not be negative")
+	}
+	if req.SignedURLTTL != nil && *req.SignedURLTTL <= 0 {
+		return req, fmt.Errorf("signed_url_ttl must be positive")
+	}
+	return req, nil
+}
+
 // streamHandler serves the requested stream file.
 func (s *Server) streamHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Received request for: %s", r.URL.Path)
 
-	filePath := filepath.Join("stream", s.outputPath, r.URL.Path[len("/stream/"):])
+	streamID, fileName, ok := strings.Cut(r.URL.Path[len("/stream/"):], "/")
+	if !ok || fileName == "" {
+		http.NotFound(w, r)
+		return
+	}
+	token := r.URL.Query().Get(streamTokenParam)
+	if token != "" {
+		if s.auth == nil || s.auth.VerifyStream(streamID, token) != nil {
+			http.Error(w, "Invalid or expired stream token", http.StatusForbidden)
+			return
+		}
+	}
+
+	if s.serveLowLatency(w, r, streamID, fileName, token) {
+		return
+	}
+	if s.serveFromStore(w, r, streamID, fileName, token) {
+		return
+	}
+
+	filePath := filepath.Join(s.streamer.StreamDir(streamID), filepath.FromSlash(fileName))
 	log.Printf("Attempting to serve file: %s", filePath)
 
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
@@ -310,17 +606,58 @@ func (s *Server) streamHandler(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	s.touch(streamID)
+
+	setContentType(w, filePath)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	ext := filepath.Ext(filePath)
+	if ext == ".m3u8" {
+		// Playlists of DVR and EVENT streams may list segments that were
+		// pruned for disk space, so they are filtered on the way out.
+		playlist, err := s.streamer.ReadPlaylist(streamID, fileName)
+		if err != nil {
+			log.Printf("Error reading playlist %s: %v", filePath, err)
+			http.Error(w, "Failed to read playlist", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		if token != "" {
+			playlist = signPlaylist(playlist, token)
+		}
+		w.Write(playlist)
+		return
+	}
+	if ext == ".mpd" && token != "" {
+		manifest, err := os.ReadFile(filePath)
+		if err != nil {
+			log.Printf("Error reading playlist %s: %v", filePath, err)
+			http.Error(w, "Failed to read playlist", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(signManifest(manifest, token))
+		return
+	}
 
-	switch filepath.Ext(filePath) {
+	http.ServeFile(w, r, filePath)
+}
+
+// setContentType sets the Content-Type of a playlist or segment from its
+// extension.
+func setContentType(w http.ResponseWriter, name string) {
+	switch filepath.Ext(name) {
 	case ".m3u8":
 		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
 	case ".ts":
 		w.Header().Set("Content-Type", "video/MP2T")
+	case ".m4s":
+		w.Header().Set("Content-Type", "video/iso.segment")
+	case ".mp4":
+		w.Header().Set("Content-Type", "video/mp4")
+	case ".mpd":
+		w.Header().Set("Content-Type", "application/dash+xml")
 	}
-
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	http.ServeFile(w, r, filePath)
 }
 
 // GetStreamPath retrieves the path for a given stream ID.
@@ -330,3 +667,120 @@ func (s *Server) GetStreamPath(streamID string) (string, bool) {
 	path, ok := s.streams[streamID]
 	return path, ok
 }
+
+// listStreamsHandler returns the state of every known stream.
+func (s *Server) listStreamsHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	ids := make([]string, 0, len(s.streams))
+	for id := range s.streams {
+		ids = append(ids, id)
+	}
+	s.mu.RUnlock()
+
+	streams := make([]streamDetails, 0, len(ids))
+	for _, id := range ids {
+		streams = append(streams, s.streamDetails(id))
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].CreatedAt.Before(streams[j].CreatedAt)
+	})
+
+	writeJSON(w, http.StatusOK, streams)
+}
+
+// getStreamHandler returns the state of a single stream.
+func (s *Server) getStreamHandler(w http.ResponseWriter, r *http.Request) {
+	streamID := r.PathValue("id")
+	if _, exists := s.GetStreamPath(streamID); !exists {
+		http.Error(w, "Stream not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.streamDetails(streamID))
+}
+
+// deleteStreamHandler stops a stream's encoder and removes its output and
+// image directories.
+func (s *Server) deleteStreamHandler(w http.ResponseWriter, r *http.Request) {
+	streamID := r.PathValue("id")
+
+	err := s.removeStream(streamID)
+	if errors.Is(err, streamer.ErrStreamNotFound) {
+		http.Error(w, "Stream not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting stream %s: %v", streamID, err)
+		http.Error(w, "Failed to remove stream", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Deleted stream %s", streamID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeStream forgets a stream, stops its encoder and removes its output
+// and image directories.
+func (s *Server) removeStream(streamID string) error {
+	s.mu.Lock()
+	_, exists := s.streams[streamID]
+	delete(s.streams, streamID)
+	delete(s.lifetimes, streamID)
+	s.mu.Unlock()
+
+	if !exists {
+		return streamer.ErrStreamNotFound
+	}
+	s.stopSources(streamID)
+
+	if err := s.registry.Delete(streamID); err != nil {
+		log.Printf("Error removing stream %s from registry: %v", streamID, err)
+	}
+
+	if err := s.streamer.StopStream(streamID); err != nil {
+		return fmt.Errorf("error removing stream output: %v", err)
+	}
+
+	if s.imagePath != "" {
+		if err := os.RemoveAll(filepath.Join(s.imagePath, streamID)); err != nil {
+			return fmt.Errorf("error removing stream images: %v", err)
+		}
+	}
+	return nil
+}
+
+// encoderHandler returns the progress statistics and recent stderr output of
+// a stream's encoder.
+func (s *Server) encoderHandler(w http.ResponseWriter, r *http.Request) {
+	streamID := r.PathValue("id")
+	if _, exists := s.GetStreamPath(streamID); !exists {
+		http.Error(w, "Stream not found", http.StatusNotFound)
+		return
+	}
+
+	status, err := s.streamer.EncoderStatus(streamID)
+	if err != nil {
+		http.Error(w, "Encoder not running", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// streamInfo returns the streamer's view of a stream, falling back to an
+// exited state when its encoder is no longer tracked.
+func (s *Server) streamInfo(streamID string) streamer.StreamInfo {
+	info, err := s.streamer.StreamInfo(streamID)
+	if err != nil {
+		return streamer.StreamInfo{ID: streamID, State: streamer.StateExited}
+	}
+	return info
+}
+
+// writeJSON encodes v as the JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/abaddouh/poll-streamer/internal/hls"
+	"github.com/abaddouh/poll-streamer/internal/registry"
+)
+
+// streamTokenParam is the query parameter carrying a signed stream token.
+const streamTokenParam = "token"
+
+// defaultSignedURLTTL is the lifetime of signed URLs requested without an
+// explicit ttl.
+const defaultSignedURLTTL = time.Hour
+
+// publicURL returns the absolute URL clients use to reach path: under the
+// configured public base URL, or else under the scheme and host r was
+// sent to.
+func (s *Server) publicURL(r *http.Request, path string) string {
+	if s.baseURL != "" {
+		return s.baseURL + path
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	host := r.Host
+	if host == "" {
+		host = fmt.Sprintf("localhost:%d", s.port)
+	}
+	return scheme + "://" + host + path
+}
+
+// signedStreamURL returns the URL of a stream's playlist carrying a token
+// that is valid for ttl.
+func (s *Server) signedStreamURL(r *http.Request, streamID, playlist string, ttl time.Duration) (string, time.Time, error) {
+	if s.auth == nil {
+		return "", time.Time{}, errors.New("no token secret configured")
+	}
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	token, err := s.auth.SignStream(streamID, expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	path := fmt.Sprintf("/stream/%s/%s?%s=%s", streamID, playlist, streamTokenParam, url.QueryEscape(token))
+	return s.publicURL(r, path), expiresAt, nil
+}
+
+// signedURLHandler returns a signed, expiring playlist URL for an existing
+// stream.
+func (s *Server) signedURLHandler(w http.ResponseWriter, r *http.Request) {
+	streamID := r.PathValue("id")
+	if _, exists := s.GetStreamPath(streamID); !exists {
+		http.Error(w, "Stream not found", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		TTL *registry.Duration `json:"ttl"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("invalid JSON body: %v", err), http.StatusBadRequest)
+		return
+	}
+	ttl := defaultSignedURLTTL
+	if req.TTL != nil {
+		ttl = time.Duration(*req.TTL)
+	}
+	if ttl <= 0 {
+		http.Error(w, "ttl must be positive", http.StatusBadRequest)
+		return
+	}
+
+	params := s.streamInfo(streamID).Params
+	signedURL, expiresAt, err := s.signedStreamURL(r, streamID, params.Playlist(), ttl)
+	if err != nil {
+		log.Printf("Error signing URL for stream %s: %v", streamID, err)
+		http.Error(w, "URL signing is not configured", http.StatusNotImplemented)
+		return
+	}
+	response := map[string]interface{}{
+		"signed_url": signedURL,
+		"expires_at": expiresAt,
+	}
+	if manifest := params.Manifest(); manifest != "" {
+		signedDASHURL, _, err := s.signedStreamURL(r, streamID, manifest, ttl)
+		if err != nil {
+			log.Printf("Error signing DASH URL for stream %s: %v", streamID, err)
+			http.Error(w, "Failed to sign stream URL", http.StatusInternalServerError)
+			return
+		}
+		response["signed_dash_url"] = signedDASHURL
+	}
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// templateAttribute matches the SegmentTemplate attributes of a DASH
+// manifest that hold segment URLs.
+var templateAttribute = regexp.MustCompile(`(initialization|media)="([^"]*)"`)
+
+// signManifest appends the stream token to the segment URL templates of a
+// DASH manifest.
+func signManifest(manifest []byte, token string) []byte {
+	query := streamTokenParam + "=" + url.QueryEscape(token)
+	return templateAttribute.ReplaceAllFunc(manifest, func(attr []byte) []byte {
+		m := templateAttribute.FindSubmatch(attr)
+		uri := string(m[2])
+		if strings.Contains(uri, "://") {
+			return attr
+		}
+		sep := "?"
+		if strings.Contains(uri, "?") {
+			sep = "&amp;"
+		}
+		return []byte(fmt.Sprintf(`%s="%s%s%s"`, m[1], uri, sep, query))
+	})
+}
+
+// signPlaylist appends the stream token to every relative URI in an HLS
+// playlist, so players carry it over to segments and sub-playlists.
+func signPlaylist(playlist []byte, token string) []byte {
+	sign := func(uri string) string {
+		if uri == "" || strings.Contains(uri, "://") {
+			return uri
+		}
+		sep := "?"
+		if strings.Contains(uri, "?") {
+			sep = "&"
+		}
+		return uri + sep + streamTokenParam + "=" + url.QueryEscape(token)
+	}
+
+	lines := bytes.Split(playlist, []byte("\n"))
+	for i, line := range lines {
+		text := strings.TrimRight(string(line), "\r")
+		switch {
+		case text == "":
+		case strings.HasPrefix(text, "#"):
+			lines[i] = hls.URIAttribute.ReplaceAllFunc(line, func(attr []byte) []byte {
+				uri := hls.URIAttribute.FindSubmatch(attr)[1]
+				return []byte(`URI="` + sign(string(uri)) + `"`)
+			})
+		default:
+			lines[i] = []byte(sign(text))
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
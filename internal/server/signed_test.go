@@ -0,0 +1,97 @@
+package server
+
+import "testing"
+
+func TestSignPlaylist(t *testing.T) {
+	tests := []struct {
+		name     string
+		playlist string
+		want     string
+	}{
+		{
+			name:     "segments",
+			playlist: "#EXTM3U\n#EXTINF:2.0,\nsegment_000001.ts\n#EXTINF:2.0,\nsegment_000002.ts\n",
+			want:     "#EXTM3U\n#EXTINF:2.0,\nsegment_000001.ts?token=1.sig\n#EXTINF:2.0,\nsegment_000002.ts?token=1.sig\n",
+		},
+		{
+			name:     "uri attributes",
+			playlist: "#EXT-X-MAP:URI=\"init.mp4\"\n#EXT-X-PART:DURATION=0.5,URI=\"part_1.0.m4s\"\n#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"part_1.1.m4s\"\n",
+			want:     "#EXT-X-MAP:URI=\"init.mp4?token=1.sig\"\n#EXT-X-PART:DURATION=0.5,URI=\"part_1.0.m4s?token=1.sig\"\n#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"part_1.1.m4s?token=1.sig\"\n",
+		},
+		{
+			name:     "existing query",
+			playlist: "#EXTINF:2.0,\nsegment.ts?v=2\n",
+			want:     "#EXTINF:2.0,\nsegment.ts?v=2&token=1.sig\n",
+		},
+		{
+			name:     "absolute uris",
+			playlist: "#EXT-X-MAP:URI=\"https://cdn.example.com/init.mp4\"\n#EXTINF:2.0,\nhttps://cdn.example.com/segment.ts\n",
+			want:     "#EXT-X-MAP:URI=\"https://cdn.example.com/init.mp4\"\n#EXTINF:2.0,\nhttps://cdn.example.com/segment.ts\n",
+		},
+		{
+			name:     "empty uri",
+			playlist: "#EXT-X-KEY:METHOD=NONE,URI=\"\"\n",
+			want:     "#EXT-X-KEY:METHOD=NONE,URI=\"\"\n",
+		},
+		{
+			name:     "variant playlists",
+			playlist: "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\n720p/playlist.m3u8\n",
+			want:     "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000\n720p/playlist.m3u8?token=1.sig\n",
+		},
+		{
+			name:     "crlf",
+			playlist: "#EXTM3U\r\n#EXTINF:2.0,\r\nsegment.ts\r\n",
+			want:     "#EXTM3U\r\n#EXTINF:2.0,\r\nsegment.ts?token=1.sig\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(signPlaylist([]byte(tt.playlist), "1.sig")); got != tt.want {
+				t.Errorf("signPlaylist =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignPlaylistEscapesToken(t *testing.T) {
+	got := string(signPlaylist([]byte("segment.ts\n"), "1.a+b/c="))
+	if want := "segment.ts?token=1.a%2Bb%2Fc%3D\n"; got != want {
+		t.Errorf("signPlaylist = %q, want %q", got, want)
+	}
+}
+
+func TestSignManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     string
+	}{
+		{
+			name:     "segment template",
+			manifest: `<SegmentTemplate initialization="init-$RepresentationID$.m4s" media="chunk-$RepresentationID$-$Number%05d$.m4s" startNumber="1"/>`,
+			want:     `<SegmentTemplate initialization="init-$RepresentationID$.m4s?token=1.sig" media="chunk-$RepresentationID$-$Number%05d$.m4s?token=1.sig" startNumber="1"/>`,
+		},
+		{
+			name:     "existing query",
+			manifest: `<SegmentTemplate media="chunk.m4s?v=2"/>`,
+			want:     `<SegmentTemplate media="chunk.m4s?v=2&amp;token=1.sig"/>`,
+		},
+		{
+			name:     "absolute urls",
+			manifest: `<SegmentTemplate media="https://cdn.example.com/chunk.m4s"/>`,
+			want:     `<SegmentTemplate media="https://cdn.example.com/chunk.m4s"/>`,
+		},
+		{
+			name:     "other attributes",
+			manifest: `<MPD mediaPresentationDuration="PT0S"><BaseURL>https://cdn.example.com/</BaseURL></MPD>`,
+			want:     `<MPD mediaPresentationDuration="PT0S"><BaseURL>https://cdn.example.com/</BaseURL></MPD>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(signManifest([]byte(tt.manifest), "1.sig")); got != tt.want {
+				t.Errorf("signManifest =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
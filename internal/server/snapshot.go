@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/abaddouh/poll-streamer/internal/streamer"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// maxSnapshotWidth bounds the width a snapshot may be resized to.
+	maxSnapshotWidth = 1920
+	// snapshotQuality is the JPEG quality of resized snapshots.
+	snapshotQuality = 85
+)
+
+// snapshotHandler returns the frame a stream currently shows as a JPEG or
+// PNG, optionally scaled down to ?width= pixels. Responses carry
+// Last-Modified and ETag headers so pollers can revalidate cheaply.
+func (s *Server) snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	streamID := r.PathValue("id")
+	format := "jpeg"
+	if strings.HasSuffix(r.URL.Path, ".png") {
+		format = "png"
+	}
+
+	width := 0
+	if v := r.URL.Query().Get("width"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxSnapshotWidth {
+			http.Error(w, fmt.Sprintf("width must be between 1 and %d", maxSnapshotWidth), http.StatusBadRequest)
+			return
+		}
+		width = n
+	}
+
+	frame, updatedAt, err := s.streamer.Snapshot(streamID)
+	if errors.Is(err, streamer.ErrStreamNotFound) {
+		http.Error(w, "Stream not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading snapshot of stream %s: %v", streamID, err)
+		http.Error(w, "Failed to read snapshot", http.StatusInternalServerError)
+		return
+	}
+	s.touch(streamID)
+
+	// The frame time identifies the frame; the rendition is part of the URL.
+	etag := fmt.Sprintf(`"%x-%s-%d"`, updatedAt.UnixNano(), format, width)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	// Answer revalidations before paying for a resize.
+	if r.Header.Get("If-None-Match") == etag {
+		w.Header().Set("Last-Modified", updatedAt.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data, err := renderSnapshot(frame, format, width)
+	if err != nil {
+		log.Printf("Error rendering snapshot of stream %s: %v", streamID, err)
+		http.Error(w, "Failed to render snapshot", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/"+format)
+	http.ServeContent(w, r, "", updatedAt, bytes.NewReader(data))
+}
+
+// renderSnapshot converts a JPEG frame to the requested format, scaling it
+// down to width pixels if it is wider. Frames that need neither are
+// returned untouched.
+func renderSnapshot(frame []byte, format string, width int) ([]byte, error) {
+	if format == "jpeg" && width == 0 {
+		return frame, nil
+	}
+	src, err := jpeg.Decode(bytes.NewReader(frame))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding frame: %v", err)
+	}
+
+	img := src
+	if b := src.Bounds(); width > 0 && width < b.Dx() {
+		height := b.Dy() * width / b.Dx()
+		if height < 1 {
+			height = 1
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+		img = dst
+	}
+
+	var buf bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: snapshotQuality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error encoding snapshot: %v", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/abaddouh/poll-streamer/internal/registry"
+	"github.com/abaddouh/poll-streamer/internal/source"
+	"github.com/abaddouh/poll-streamer/internal/streamer"
+)
+
+// maxSources bounds the number of sources a stream can be bound to.
+const maxSources = 8
+
+// streamDetails is the state of a stream as reported by the API.
+type streamDetails struct {
+	streamer.StreamInfo
+	Sources []source.Status `json:"sources,omitempty"`
+}
+
+// resolveSources fills the defaults of the sources requested for a stream
+// and validates them, without touching the disk. It returns the sources to
+// record along with their configurations. The directories of directory
+// sources are created by createSourceDirs.
+func (s *Server) resolveSources(streamID string, specs []registry.Source) ([]registry.Source, []source.Config, error) {
+	if len(specs) > maxSources {
+		return nil, nil, fmt.Errorf("a stream can have at most %d sources", maxSources)
+	}
+
+	resolved := make([]registry.Source, 0, len(specs))
+	configs := make([]source.Config, 0, len(specs))
+	for i, spec := range specs {
+		cfg := source.Config{
+			Type:     spec.Type,
+			URL:      spec.URL,
+			Interval: time.Duration(spec.Interval),
+			Timeout:  time.Duration(spec.Timeout),
+			Headers:  spec.Headers,
+			Path:     spec.Path,
+
+			AllowedNetworks: s.sourceAllow,
+		}.WithDefaults()
+		if err := cfg.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("sources[%d]: %v", i, err)
+		}
+		if cfg.Type == source.TypeDirectory {
+			// Directory sources are confined to the stream's own image
+			// directory, so a stream cannot read another stream's frames.
+			if !filepath.IsLocal(spec.Path) || filepath.Clean(spec.Path) == "." {
+				return nil, nil, fmt.Errorf("sources[%d]: source path must be a subdirectory of the stream's image directory", i)
+			}
+			cfg.Path = filepath.Join(s.imagePath, streamID, filepath.Clean(spec.Path))
+		}
+
+		spec.Type = cfg.Type
+		spec.Interval = registry.Duration(cfg.Interval)
+		spec.Timeout = registry.Duration(cfg.Timeout)
+		resolved = append(resolved, spec)
+		configs = append(configs, cfg)
+	}
+	return resolved, configs, nil
+}
+
+// createSourceDirs creates the directories of the directory sources of a
+// stream.
+func (s *Server) createSourceDirs(streamID string, configs []source.Config) error {
+	root := filepath.Join(s.imagePath, streamID)
+	for i, cfg := range configs {
+		if cfg.Type != source.TypeDirectory {
+			continue
+		}
+		name, err := filepath.Rel(root, cfg.Path)
+		if err == nil {
+			_, err = s.sourceDir(streamID, name)
+		}
+		if err != nil {
+			return fmt.Errorf("sources[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// sourceDir creates the directory of a directory source below the stream's
+// image directory. Symbolic links are refused along the way, so the source
+// cannot lead out of the stream's directory, nor create directories
+// outside it.
+func (s *Server) sourceDir(streamID, name string) (string, error) {
+	path := filepath.Join(s.imagePath, streamID)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return "", fmt.Errorf("failed to create the stream's image directory: %v", err)
+	}
+	for _, part := range strings.Split(filepath.Clean(name), string(filepath.Separator)) {
+		path = filepath.Join(path, part)
+		fi, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(path, 0755); err != nil {
+				return "", fmt.Errorf("failed to create source directory %s: %v", name, err)
+			}
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to inspect source directory %s: %v", name, err)
+		}
+		if !fi.IsDir() {
+			return "", fmt.Errorf("source path %s is not a directory within the stream's image directory", name)
+		}
+	}
+	return path, nil
+}
+
+// startSources starts feeding a stream from its sources, replacing those
+// it was bound to. Sources started for a stream that was removed meanwhile
+// are stopped right away.
+func (s *Server) startSources(streamID string, configs []source.Config) {
+	started := make([]source.Source, 0, len(configs))
+	for _, cfg := range configs {
+		src, err := source.New(streamID, cfg)
+		if err != nil {
+			log.Printf("Error creating %s source of stream %s: %v", cfg.Type, streamID, err)
+			continue
+		}
+		src.Start(context.Background(), s.jobs)
+		started = append(started, src)
+		log.Printf("Started %s source of stream %s", cfg.Type, streamID)
+	}
+
+	s.mu.Lock()
+	previous := s.sources[streamID]
+	_, exists := s.streams[streamID]
+	if exists {
+		s.sources[streamID] = started
+	} else {
+		previous = append(previous, started...)
+		delete(s.sources, streamID)
+	}
+	s.mu.Unlock()
+	for _, src := range previous {
+		src.Stop()
+	}
+}
+
+// stopSources stops the sources of a stream, if it has any.
+func (s *Server) stopSources(streamID string) {
+	s.mu.Lock()
+	sources := s.sources[streamID]
+	delete(s.sources, streamID)
+	s.mu.Unlock()
+	for _, src := range sources {
+		src.Stop()
+	}
+}
+
+// stopAllSources stops the sources of every stream.
+func (s *Server) stopAllSources() {
+	s.mu.Lock()
+	all := s.sources
+	s.sources = make(map[string][]source.Source)
+	s.mu.Unlock()
+	for _, sources := range all {
+		for _, src := range sources {
+			src.Stop()
+		}
+	}
+}
+
+// putSourcesHandler binds a stream to the sources in the request body, a
+// JSON array in the format of the sources of POST /generate-stream,
+// replacing those it was bound to.
+func (s *Server) putSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	streamID := r.PathValue("id")
+	if _, exists := s.GetStreamPath(streamID); !exists {
+		http.Error(w, "Stream not found", http.StatusNotFound)
+		return
+	}
+
+	var specs []registry.Source
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&specs); err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON body: %v", err), http.StatusBadRequest)
+		return
+	}
+	resolved, configs, err := s.resolveSources(streamID, specs)
+	if err == nil {
+		err = s.createSourceDirs(streamID, configs)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(configs) > 0 {
+		s.startSources(streamID, configs)
+	} else {
+		s.stopSources(streamID)
+	}
+	s.recordSources(streamID, resolved)
+	log.Printf("Bound stream %s to %d sources", streamID, len(configs))
+	writeJSON(w, http.StatusOK, s.streamDetails(streamID))
+}
+
+// deleteSourcesHandler stops the sources of a stream and unbinds them. The
+// stream keeps being fed by its image directory and frame uploads.
+func (s *Server) deleteSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	streamID := r.PathValue("id")
+	if _, exists := s.GetStreamPath(streamID); !exists {
+		http.Error(w, "Stream not found", http.StatusNotFound)
+		return
+	}
+
+	s.stopSources(streamID)
+	s.recordSources(streamID, nil)
+	log.Printf("Unbound the sources of stream %s", streamID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// recordSources updates the sources of a stream in the registry, so a
+// restored stream keeps the sources it was last bound to.
+func (s *Server) recordSources(streamID string, sources []registry.Source) {
+	rec, ok := s.registry.Get(streamID)
+	if !ok {
+		return
+	}
+	rec.Sources = sources
+	if err := s.registry.Put(rec); err != nil {
+		log.Printf("Error registering the sources of stream %s: %v", streamID, err)
+	}
+}
+
+// streamDetails returns the state of a stream and of its sources.
+func (s *Server) streamDetails(streamID string) streamDetails {
+	details := streamDetails{StreamInfo: s.streamInfo(streamID)}
+	s.mu.RLock()
+	sources := s.sources[streamID]
+	s.mu.RUnlock()
+	for _, src := range sources {
+		details.Sources = append(details.Sources, src.Status())
+	}
+	return details
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// webhookAttempts bounds the deliveries of a single event.
+	webhookAttempts = 3
+	// webhookTimeout bounds a single delivery.
+	webhookTimeout = 10 * time.Second
+)
+
+// ExpiryWebhook returns an OnExpire hook that POSTs every event as JSON to
+// rawURL in the background, retrying failed deliveries with backoff.
+func ExpiryWebhook(rawURL string) (func(ExpiryEvent), error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q, expected an http:// or https:// URL", rawURL)
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+
+	return func(event ExpiryEvent) {
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Error encoding expiry event of stream %s: %v", event.StreamID, err)
+			return
+		}
+		go func() {
+			delay := time.Second
+			for attempt := 1; ; attempt++ {
+				err := postJSON(client, rawURL, body)
+				if err == nil {
+					return
+				}
+				if attempt == webhookAttempts {
+					log.Printf("Error delivering expiry event of stream %s: %v", event.StreamID, err)
+					return
+				}
+				time.Sleep(delay)
+				delay *= 2
+			}
+		}()
+	}, nil
+}
+
+// postJSON POSTs body to rawURL, failing on any non-2xx response.
+func postJSON(client *http.Client, rawURL string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
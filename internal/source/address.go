@@ -0,0 +1,126 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// reservedNetworks are ranges HTTP sources may not reach unless allowed,
+// on top of the loopback, private, link-local, unspecified and multicast
+// addresses net.IP reports.
+var reservedNetworks = mustParseNetworks("0.0.0.0/8,100.64.0.0/10,192.0.0.0/24,198.18.0.0/15,240.0.0.0/4")
+
+// nat64Network is the well-known NAT64 prefix, whose addresses carry an
+// IPv4 address in their last four bytes.
+var nat64Network = mustParseNetworks("64:ff9b::/96")[0]
+
+// ParseNetworks parses a comma-separated list of CIDR ranges and IP
+// addresses, such as "10.0.0.0/8,192.168.1.20".
+func ParseNetworks(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network %q", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", item)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func mustParseNetworks(s string) []*net.IPNet {
+	networks, err := ParseNetworks(s)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
+
+// embeddedIPv4 returns the IPv4 address carried by an IPv4-mapped or NAT64
+// address, which reaches that IPv4 host, or ip itself.
+func embeddedIPv4(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	if len(ip) == net.IPv6len && nat64Network.Contains(ip) {
+		return ip[12:]
+	}
+	return ip
+}
+
+// checkIP rejects addresses that are not publicly routable, such as the
+// server's own loopback, cloud metadata endpoints or hosts on the private
+// network, unless they lie in AllowedNetworks. IPv4-mapped and NAT64
+// addresses are checked as the IPv4 address they embed.
+func (c Config) checkIP(ip net.IP) error {
+	target := embeddedIPv4(ip)
+	for _, network := range c.AllowedNetworks {
+		if network.Contains(ip) || network.Contains(target) {
+			return nil
+		}
+	}
+	if target.IsLoopback() || target.IsPrivate() || target.IsLinkLocalUnicast() || target.IsLinkLocalMulticast() ||
+		target.IsInterfaceLocalMulticast() || target.IsMulticast() || target.IsUnspecified() {
+		return fmt.Errorf("source address %s is not publicly routable", ip)
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(target) {
+			return fmt.Errorf("source address %s is not publicly routable", ip)
+		}
+	}
+	return nil
+}
+
+// checkHost resolves the host of a source URL and checks every address it
+// resolves to.
+func (c Config) checkHost(host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		return c.checkIP(ip)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve source host %s: %v", host, err)
+	}
+	for _, addr := range addrs {
+		if err := c.checkIP(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dialControl checks the address of every connection an HTTP source opens
+// once it is resolved, so neither redirects nor DNS records changing after
+// validation reach a blocked address.
+func (c Config) dialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid source address %s", address)
+	}
+	return c.checkIP(ip)
+}
@@ -0,0 +1,96 @@
+package source
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCheckIP(t *testing.T) {
+	allowed := mustParseNetworks("10.1.0.0/16,192.168.1.20,fd00:1::/64")
+
+	tests := []struct {
+		ip      string
+		allowed bool
+		want    bool
+	}{
+		{"93.184.216.34", false, true},
+		{"2606:2800:220:1:248:1893:25c8:1946", false, true},
+		{"127.0.0.1", false, false},
+		{"::1", false, false},
+		{"10.0.0.1", false, false},
+		{"172.16.5.4", false, false},
+		{"192.168.1.1", false, false},
+		{"fd00::1", false, false},
+		{"169.254.169.254", false, false},
+		{"fe80::1", false, false},
+		{"224.0.0.1", false, false},
+		{"ff02::1", false, false},
+		{"0.0.0.0", false, false},
+		{"::", false, false},
+		{"0.1.2.3", false, false},
+		{"100.64.0.1", false, false},
+		{"192.0.0.8", false, false},
+		{"198.18.0.1", false, false},
+		{"240.0.0.1", false, false},
+		{"255.255.255.255", false, false},
+
+		// IPv4-mapped addresses reach the IPv4 host they embed.
+		{"::ffff:127.0.0.1", false, false},
+		{"::ffff:169.254.169.254", false, false},
+		{"::ffff:10.0.0.1", false, false},
+		{"::ffff:93.184.216.34", false, true},
+
+		// So do NAT64 addresses, through the NAT64 gateway.
+		{"64:ff9b::7f00:1", false, false},
+		{"64:ff9b::a9fe:a9fe", false, false},
+		{"64:ff9b::a00:1", false, false},
+		{"64:ff9b::c0a8:101", false, false},
+		{"64:ff9b::5db8:d822", false, true},
+
+		{"10.1.2.3", true, true},
+		{"10.2.0.1", true, false},
+		{"192.168.1.20", true, true},
+		{"192.168.1.21", true, false},
+		{"::ffff:10.1.2.3", true, true},
+		{"64:ff9b::a01:203", true, true},
+		{"fd00:1::5", true, true},
+		{"fd00:2::5", true, false},
+		{"127.0.0.1", true, false},
+	}
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		if ip == nil {
+			t.Fatalf("invalid test address %s", tt.ip)
+		}
+		var c Config
+		if tt.allowed {
+			c.AllowedNetworks = allowed
+		}
+		err := c.checkIP(ip)
+		if got := err == nil; got != tt.want {
+			t.Errorf("checkIP(%s) with allow list %v = %v, want allowed %v", tt.ip, tt.allowed, err, tt.want)
+		}
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks(" 10.0.0.0/8, 192.168.1.20 ,,fd00::/8,::1")
+	if err != nil {
+		t.Fatalf("ParseNetworks: %v", err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.20/32", "fd00::/8", "::1/128"}
+	if len(networks) != len(want) {
+		t.Fatalf("ParseNetworks returned %v, want %v", networks, want)
+	}
+	for i, network := range networks {
+		if network.String() != want[i] {
+			t.Errorf("network %d = %s, want %s", i, network, want[i])
+		}
+	}
+
+	for _, input := range []string{"10.0.0.0/33", "example.com", "10.0.0", "fd00::/129"} {
+		if _, err := ParseNetworks(input); err == nil {
+			t.Errorf("ParseNetworks(%q) succeeded, want an error", input)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/abaddouh/poll-streamer/internal/metrics"
+	"github.com/abaddouh/poll-streamer/internal/watcher"
+)
+
+const (
+	// DefaultPollInterval is the time between two polls if none is configured.
+	DefaultPollInterval = 5 * time.Second
+	// DefaultPollTimeout bounds a single poll if no timeout is configured.
+	DefaultPollTimeout = 10 * time.Second
+	// MinPollInterval is the shortest interval a URL may be polled at.
+	MinPollInterval = 250 * time.Millisecond
+	// maxImageSize bounds the size of a fetched image.
+	maxImageSize = 10 << 20
+	// maxRedirects bounds the redirects followed by a poll.
+	maxRedirects = 5
+)
+
+// Poller is a source that fetches the frames of a stream from an HTTP(S)
+// URL on a schedule, such as the snapshot URL of an IP camera or a chart
+// renderer.
+type Poller struct {
+	streamID string
+	cfg      Config
+	client   *http.Client
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	// Validators of the last image enqueued, used to make conditional
+	// requests, and its digest, to skip unchanged images from servers that
+	// send no validators.
+	etag         string
+	lastModified string
+	digest       [sha256.Size]byte
+
+	mu          sync.Mutex
+	failing     bool
+	polls       int
+	frames      int
+	lastPollAt  time.Time
+	lastFrameAt time.Time
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// NewPoller creates a poller for a stream. The config is expected to have
+// been passed through WithDefaults and Validate. Every address the poller
+// connects to, including redirect targets, is checked against the config's
+// address policy, and no proxy is used so the check applies to the source
+// itself.
+func NewPoller(streamID string, cfg Config) *Poller {
+	dialer := &net.Dialer{Timeout: cfg.Timeout, Control: cfg.dialControl}
+	return &Poller{
+		streamID: streamID,
+		cfg:      cfg,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: cfg.Timeout,
+				MaxIdleConns:        1,
+				IdleConnTimeout:     2 * cfg.Interval,
+			},
+			CheckRedirect: checkRedirect,
+		},
+		done: make(chan struct{}),
+	}
+}
+
+// checkRedirect follows at most maxRedirects redirects, to http and https
+// URLs only.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+	}
+	return nil
+}
+
+// Start polls the URL until ctx is cancelled or Stop is called,
+// enqueueing every new image on jobs.
+func (p *Poller) Start(ctx context.Context, jobs chan<- watcher.WatcherJob) {
+	ctx, p.cancel = context.WithCancel(ctx)
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			p.poll(ctx, jobs)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops polling and waits for a poll in flight to be aborted.
+func (p *Poller) Stop() {
+	p.cancel()
+	<-p.done
+}
+
+// Status reports the state of the poller.
+func (p *Poller) Status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := Status{
+		Type:      TypeHTTP,
+		URL:       redact(p.cfg.URL),
+		Interval:  p.cfg.Interval.String(),
+		Polls:     p.polls,
+		Frames:    p.frames,
+		LastError: p.lastError,
+	}
+	if !p.lastPollAt.IsZero() {
+		lastPollAt := p.lastPollAt
+		status.LastPollAt = &lastPollAt
+	}
+	if !p.lastFrameAt.IsZero() {
+		lastFrameAt := p.lastFrameAt
+		status.LastFrameAt = &lastFrameAt
+	}
+	if !p.lastErrorAt.IsZero() {
+		lastErrorAt := p.lastErrorAt
+		status.LastErrorAt = &lastErrorAt
+	}
+	return status
+}
+
+// poll fetches the source once and enqueues the image if it changed.
+func (p *Poller) poll(ctx context.Context, jobs chan<- watcher.WatcherJob) {
+	data, err := p.fetch(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.polls++
+	p.lastPollAt = time.Now()
+	if err != nil {
+		if !p.failing {
+			log.Printf("Error polling source of stream %s: %v", p.streamID, err)
+		}
+		p.failing = true
+		p.lastError = err.Error()
+		p.lastErrorAt = time.Now()
+		return
+	}
+	if p.failing {
+		log.Printf("Polling source of stream %s recovered", p.streamID)
+		p.failing = false
+	}
+	if data == nil {
+		return
+	}
+
+	select {
+	case jobs <- watcher.WatcherJob{StreamID: p.streamID, Data: data}:
+		metrics.JobsEnqueued.WithLabelValues("poller").Inc()
+		p.frames++
+		p.lastFrameAt = time.Now()
+		log.Printf("Job enqueued: StreamID=%s, polled %d bytes", p.streamID, len(data))
+	default:
+		// Fetch the image again on the next poll.
+		metrics.JobsDropped.WithLabelValues("poller").Inc()
+		p.etag, p.lastModified, p.digest = "", "", [sha256.Size]byte{}
+	}
+}
+
+// fetch requests the source, conditionally on the validators of the last
+// image. It returns nil data if the image did not change.
+func (p *Poller) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range p.cfg.Headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	if p.etag != "" {
+		req.Header.Set("If-None-Match", p.etag)
+	}
+	if p.lastModified != "" {
+		req.Header.Set("If-Modified-Since", p.lastModified)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading image: %v", err)
+	}
+	if len(data) > maxImageSize {
+		return nil, fmt.Errorf("image exceeds the %d byte limit", maxImageSize)
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty response")
+	}
+
+	p.etag = resp.Header.Get("ETag")
+	p.lastModified = resp.Header.Get("Last-Modified")
+	digest := sha256.Sum256(data)
+	if digest == p.digest {
+		return nil, nil
+	}
+	p.digest = digest
+	return data, nil
+}
+
+// redact hides the password of a URL.
+func redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
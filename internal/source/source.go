@@ -0,0 +1,133 @@
+// Package source defines where the frames of streams come from. A source,
+// such as a watched directory or a polled URL, feeds the images it finds
+// into the shared job queue.
+package source
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/abaddouh/poll-streamer/internal/watcher"
+)
+
+// Source types.
+const (
+	TypeHTTP      = "http"
+	TypeDirectory = "directory"
+)
+
+// Source produces frames for one or more streams.
+type Source interface {
+	// Start feeds frames to jobs until ctx is cancelled or Stop is called.
+	Start(ctx context.Context, jobs chan<- watcher.WatcherJob)
+	// Stop stops a started source and waits for it to wind down.
+	Stop()
+	// Status reports the state of the source.
+	Status() Status
+}
+
+// Status reports the state of a source. Fields that do not apply to the
+// source's type are left empty.
+type Status struct {
+	Type string `json:"type"`
+	// URL is the URL polled, with any password redacted.
+	URL string `json:"url,omitempty"`
+	// Path is the directory watched.
+	Path        string     `json:"path,omitempty"`
+	Interval    string     `json:"interval,omitempty"`
+	Polls       int        `json:"polls,omitempty"`
+	Frames      int        `json:"frames"`
+	LastPollAt  *time.Time `json:"last_poll_at,omitempty"`
+	LastFrameAt *time.Time `json:"last_frame_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// Config describes a source bound to a stream.
+type Config struct {
+	// Type is TypeHTTP or TypeDirectory, and defaults to TypeHTTP.
+	Type string
+
+	// URL is polled every Interval by HTTP sources, each request giving up
+	// after Timeout. Headers are sent with every request, e.g. an
+	// Authorization header. Basic auth credentials may also be given in
+	// the URL.
+	URL      string
+	Interval time.Duration
+	Timeout  time.Duration
+	Headers  map[string]string
+	// AllowedNetworks lists the ranges HTTP sources may reach on top of
+	// public addresses. Loopback, private, link-local and other reserved
+	// addresses are refused otherwise.
+	AllowedNetworks []*net.IPNet
+
+	// Path is the directory watched by directory sources.
+	Path string
+}
+
+// WithDefaults fills unset fields of a source's configuration.
+func (c Config) WithDefaults() Config {
+	if c.Type == "" {
+		c.Type = TypeHTTP
+	}
+	if c.Type == TypeHTTP {
+		if c.Interval == 0 {
+			c.Interval = DefaultPollInterval
+		}
+		if c.Timeout == 0 {
+			c.Timeout = DefaultPollTimeout
+		}
+	}
+	return c
+}
+
+// Validate checks a source's configuration.
+func (c Config) Validate() error {
+	switch c.Type {
+	case TypeHTTP:
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("source url must be an http:// or https:// URL")
+		}
+		if c.Interval < MinPollInterval {
+			return fmt.Errorf("source interval must be at least %v", MinPollInterval)
+		}
+		if c.Timeout <= 0 {
+			return fmt.Errorf("source timeout must be positive")
+		}
+		for name := range c.Headers {
+			if name == "" {
+				return fmt.Errorf("source headers must not have an empty name")
+			}
+		}
+		if err := c.checkHost(u.Hostname()); err != nil {
+			return err
+		}
+	case TypeDirectory:
+		if c.Path == "" {
+			return fmt.Errorf("source path is required for directory sources")
+		}
+	default:
+		return fmt.Errorf("unsupported source type %q", c.Type)
+	}
+	return nil
+}
+
+// New creates a source feeding the frames it finds to a stream. The config
+// is expected to have been passed through WithDefaults and Validate.
+func New(streamID string, cfg Config) (Source, error) {
+	switch cfg.Type {
+	case TypeHTTP:
+		return NewPoller(streamID, cfg), nil
+	case TypeDirectory:
+		w, err := watcher.NewStream(cfg.Path, streamID)
+		if err != nil {
+			return nil, err
+		}
+		return NewWatcher(w), nil
+	}
+	return nil, fmt.Errorf("unsupported source type %q", cfg.Type)
+}
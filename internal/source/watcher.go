@@ -0,0 +1,45 @@
+package source
+
+import (
+	"context"
+
+	"github.com/abaddouh/poll-streamer/internal/watcher"
+)
+
+// Watcher is a source fed by an fsnotify watcher, either over the whole
+// image directory or over a single stream's directory.
+type Watcher struct {
+	watcher *watcher.Watcher
+	cancel  context.CancelFunc
+}
+
+func NewWatcher(w *watcher.Watcher) *Watcher {
+	return &Watcher{watcher: w}
+}
+
+func (s *Watcher) Start(ctx context.Context, jobs chan<- watcher.WatcherJob) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.watcher.Start(ctx, jobs)
+}
+
+func (s *Watcher) Stop() {
+	s.cancel()
+	<-s.watcher.Done()
+}
+
+func (s *Watcher) Status() Status {
+	stats := s.watcher.Stats()
+	status := Status{
+		Type:      TypeDirectory,
+		Path:      s.watcher.Path(),
+		Frames:    stats.Frames,
+		LastError: stats.LastError,
+	}
+	if !stats.LastFrameAt.IsZero() {
+		status.LastFrameAt = &stats.LastFrameAt
+	}
+	if !stats.LastErrorAt.IsZero() {
+		status.LastErrorAt = &stats.LastErrorAt
+	}
+	return status
+}
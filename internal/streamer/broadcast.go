@@ -0,0 +1,105 @@
+package streamer
+
+import (
+	"sync"
+
+	"github.com/abaddouh/poll-streamer/internal/metrics"
+)
+
+// frameBroadcaster fans the frames held by a stream out to live viewers.
+// Each viewer has a single-frame mailbox, so a viewer that falls behind
+// skips to the most recent frame instead of holding back the producer.
+type frameBroadcaster struct {
+	mu     sync.Mutex
+	subs   map[chan []byte]struct{}
+	closed bool
+}
+
+func newFrameBroadcaster() *frameBroadcaster {
+	return &frameBroadcaster{subs: make(map[chan []byte]struct{})}
+}
+
+// subscribe registers a viewer, handing it initial first if not nil. It
+// returns nil once the broadcaster is closed. The initial frame is queued
+// under the lock, so close can never race it onto a closed channel.
+func (b *frameBroadcaster) subscribe(initial []byte) chan []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	ch := make(chan []byte, 1)
+	if initial != nil {
+		ch <- initial
+	}
+	b.subs[ch] = struct{}{}
+	metrics.MJPEGViewers.Inc()
+	return ch
+}
+
+// unsubscribe removes a viewer.
+func (b *frameBroadcaster) unsubscribe(ch chan []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		metrics.MJPEGViewers.Dec()
+	}
+}
+
+// publish hands a frame to every viewer without blocking.
+func (b *frameBroadcaster) publish(frame []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- frame:
+			continue
+		default:
+		}
+		// Replace the frame the viewer has not picked up yet.
+		select {
+		case <-ch:
+			metrics.MJPEGFramesDropped.Inc()
+		default:
+		}
+		select {
+		case ch <- frame:
+		default:
+		}
+	}
+}
+
+// close ends every subscription.
+func (b *frameBroadcaster) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for ch := range b.subs {
+		close(ch)
+		delete(b.subs, ch)
+		metrics.MJPEGViewers.Dec()
+	}
+}
+
+// SubscribeFrames returns a channel receiving the JPEG frames held by a
+// stream, starting with the current one, and a function ending the
+// subscription. The channel is closed when the stream stops. Viewers that
+// cannot keep up miss frames rather than slowing the stream down.
+func (s *Streamer) SubscribeFrames(streamID string) (<-chan []byte, func(), error) {
+	s.mu.Lock()
+	process, exists := s.activeStreams[streamID]
+	s.mu.Unlock()
+	if !exists {
+		return nil, nil, ErrStreamNotFound
+	}
+
+	ch := process.frames.subscribe(process.heldFrame())
+	if ch == nil {
+		return nil, nil, ErrStreamNotFound
+	}
+	return ch, func() { process.frames.unsubscribe(ch) }, nil
+}
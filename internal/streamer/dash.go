@@ -0,0 +1,47 @@
+package streamer
+
+import (
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	// dashManifest is the name of the DASH manifest of a stream.
+	dashManifest = "manifest.mpd"
+	// dashExtraWindow is how many segments are kept on disk after leaving
+	// the manifest window, for players that are slightly behind.
+	dashExtraWindow = 2
+)
+
+// Manifest returns the name of the DASH manifest of a stream, or "" if the
+// stream is not published as DASH.
+func (p EncodingParams) Manifest() string {
+	if p.DASH {
+		return dashManifest
+	}
+	return ""
+}
+
+// dashArgs returns the FFmpeg arguments writing a stream with the DASH
+// muxer: a dynamic manifest.mpd with a SegmentTimeline, plus HLS playlists
+// over the same fMP4 segments.
+func dashArgs(streamPath string, params EncodingParams) ([]string, error) {
+	args, err := encodeArgs(params)
+	if err != nil {
+		return nil, err
+	}
+	return append(args,
+		"-f", "dash",
+		"-seg_duration", strconv.Itoa(params.HLSTime),
+		"-window_size", strconv.Itoa(params.HLSListSize),
+		"-extra_window_size", strconv.Itoa(dashExtraWindow),
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-adaptation_sets", "id=0,streams=v",
+		"-init_seg_name", "init-$RepresentationID$.mp4",
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		// Also write master.m3u8 and a media playlist per representation.
+		"-hls_playlist", "1",
+		filepath.Join(streamPath, dashManifest),
+	), nil
+}
@@ -0,0 +1,256 @@
+package streamer
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/abaddouh/poll-streamer/internal/hls"
+)
+
+const (
+	// PlaylistTypeEvent keeps every segment in the playlist until the
+	// stream ends.
+	PlaylistTypeEvent = "event"
+	// pruneInterval is how often free disk space is checked.
+	pruneInterval = 30 * time.Second
+)
+
+// Retains reports whether a stream keeps segments behind the live edge,
+// either for a DVR window or in an EVENT playlist.
+func (p EncodingParams) Retains() bool {
+	return p.DVRWindow > 0 || p.PlaylistType == PlaylistTypeEvent
+}
+
+// prunable reports whether the retained segments of a stream may be
+// deleted to free disk space, which only DVR playlists allow.
+func (p EncodingParams) prunable() bool {
+	return p.DVRWindow > 0 && p.PlaylistType != PlaylistTypeEvent
+}
+
+// playlistSegments returns how many segments FFmpeg keeps in the playlist,
+// 0 meaning all of them.
+func (p EncodingParams) playlistSegments() int {
+	switch {
+	case p.PlaylistType == PlaylistTypeEvent:
+		return 0
+	case p.DVRWindow > 0:
+		return (p.DVRWindow + p.HLSTime - 1) / p.HLSTime
+	}
+	return p.HLSListSize
+}
+
+// prunedSegments remembers, per playlist directory, the lowest segment
+// number left after segments were pruned to free disk space. The floor is
+// re-derived from the segments left on disk when a stream is resumed.
+type prunedSegments struct {
+	mu    sync.Mutex
+	below map[string]int
+}
+
+func (p *prunedSegments) set(dir string, number int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.below == nil {
+		p.below = make(map[string]int)
+	}
+	if number > p.below[dir] {
+		p.below[dir] = number
+	}
+}
+
+func (p *prunedSegments) get(dir string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.below[dir]
+}
+
+// restore sets the floor of every playlist directory under streamPath to
+// its lowest segment left on disk, so segments pruned before a restart stay
+// hidden from the playlist FFmpeg appends to.
+func (p *prunedSegments) restore(streamPath string) {
+	lowest := make(map[string]int)
+	filepath.WalkDir(streamPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		n, ok := segmentNumber(d.Name())
+		if !ok {
+			return nil
+		}
+		dir := filepath.Dir(path)
+		if low, seen := lowest[dir]; !seen || n < low {
+			lowest[dir] = n
+		}
+		return nil
+	})
+	for dir, n := range lowest {
+		if n > 0 {
+			p.set(dir, n)
+		}
+	}
+}
+
+// segmentNumber parses the sequence number of a segment file such as
+// "segment042.ts".
+func segmentNumber(name string) (int, bool) {
+	stem, ok := strings.CutPrefix(name, "segment")
+	if !ok {
+		return 0, false
+	}
+	stem = strings.TrimSuffix(stem, filepath.Ext(stem))
+	n, err := strconv.Atoi(stem)
+	return n, err == nil
+}
+
+// ReadPlaylist returns an HLS playlist of a stream. Segments pruned to free
+// disk space are left out, so players never request them.
+func (s *Streamer) ReadPlaylist(streamID, name string) ([]byte, error) {
+	path := filepath.Join(s.StreamDir(streamID), filepath.FromSlash(name))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	process, exists := s.activeStreams[streamID]
+	s.mu.Unlock()
+	if !exists {
+		return data, nil
+	}
+	if floor := process.pruned.get(filepath.Dir(path)); floor > 0 {
+		data = dropPrunedSegments(data, floor)
+	}
+	return data, nil
+}
+
+// dropPrunedSegments removes the segments numbered below floor from a
+// media playlist. Only DVR playlists are pruned; EVENT playlists must never
+// lose segments.
+func dropPrunedSegments(playlist []byte, floor int) []byte {
+	return hls.TrimSegments(playlist, func(uri string) bool {
+		n, ok := segmentNumber(filepath.Base(uri))
+		return !ok || n >= floor
+	})
+}
+
+// freeSpace returns the bytes available to unprivileged users on the file
+// system holding path.
+func freeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+// RunDiskPruner deletes the oldest retained segments of DVR streams
+// whenever free space on the output volume drops below minFree bytes, until
+// ctx is cancelled. The newest hls_list_size segments of every playlist are
+// always kept. EVENT playlists are never pruned, as they must keep every
+// segment they list.
+func (s *Streamer) RunDiskPruner(ctx context.Context, minFree uint64) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.pruneForDisk(minFree)
+		}
+	}
+}
+
+// prunable is a retained segment that may be deleted to free disk space.
+type prunable struct {
+	process *StreamProcess
+	path    string
+	number  int
+	modTime time.Time
+}
+
+// pruneForDisk deletes the oldest prunable segments across streams until
+// minFree bytes are available again.
+func (s *Streamer) pruneForDisk(minFree uint64) {
+	free, err := freeSpace(s.outputPath)
+	if err != nil {
+		log.Printf("Error checking free disk space: %v", err)
+		return
+	}
+	if free >= minFree {
+		return
+	}
+
+	s.mu.Lock()
+	streams := make(map[string]*StreamProcess)
+	for id, process := range s.activeStreams {
+		if process.params.prunable() {
+			streams[id] = process
+		}
+	}
+	s.mu.Unlock()
+
+	var candidates []prunable
+	for id, process := range streams {
+		candidates = append(candidates, prunableSegments(s.StreamDir(id), process)...)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].modTime.Before(candidates[j].modTime) })
+
+	pruned := 0
+	for _, c := range candidates {
+		if free >= minFree {
+			break
+		}
+		info, err := os.Stat(c.path)
+		if err != nil {
+			continue
+		}
+		// Hide the segment from playlists before it disappears.
+		c.process.pruned.set(filepath.Dir(c.path), c.number+1)
+		if err := os.Remove(c.path); err != nil {
+			log.Printf("Error pruning segment %s: %v", c.path, err)
+			continue
+		}
+		pruned++
+		free += uint64(info.Size())
+	}
+	log.Printf("Free disk space below %d bytes, pruned %d retained segments", minFree, pruned)
+}
+
+// prunableSegments lists the segments of a stream that lie behind the
+// newest hls_list_size segments of their playlist.
+func prunableSegments(streamPath string, process *StreamProcess) []prunable {
+	var segments []prunable
+	byDir := make(map[string][]prunable)
+	filepath.WalkDir(streamPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		n, ok := segmentNumber(d.Name())
+		if !ok {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		dir := filepath.Dir(path)
+		byDir[dir] = append(byDir[dir], prunable{process: process, path: path, number: n, modTime: info.ModTime()})
+		return nil
+	})
+	for _, dirSegments := range byDir {
+		sort.Slice(dirSegments, func(i, j int) bool { return dirSegments[i].number < dirSegments[j].number })
+		if keep := process.params.HLSListSize; len(dirSegments) > keep {
+			segments = append(segments, dirSegments[:len(dirSegments)-keep]...)
+		}
+	}
+	return segments
+}
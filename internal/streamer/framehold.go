@@ -0,0 +1,212 @@
+package streamer
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/abaddouh/poll-streamer/internal/metrics"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+)
+
+const (
+	// frameWriteTimeout bounds a single frame write into the FIFO so a
+	// stalled encoder cannot wedge the frame clock. An encoder that misses
+	// it is restarted.
+	frameWriteTimeout = 2 * time.Second
+	// frameQuality is the JPEG quality used when frames are re-encoded.
+	frameQuality = 90
+)
+
+// encodeFrame turns an encoded image of any supported format into a JPEG of
+// exactly width x height, letterboxing it to preserve its aspect ratio. JPEGs
+// that already have the right size are passed through untouched.
+func encodeFrame(data []byte, width, height int) ([]byte, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %v", err)
+	}
+	if format == "jpeg" && config.Width == width && config.Height == height &&
+		(config.ColorModel == color.YCbCrModel || config.ColorModel == color.GrayModel) {
+		return data, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %v", err)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.Black, image.Point{}, draw.Src)
+
+	sb := src.Bounds()
+	scale := float64(width) / float64(sb.Dx())
+	if s := float64(height) / float64(sb.Dy()); s < scale {
+		scale = s
+	}
+	tw, th := int(float64(sb.Dx())*scale), int(float64(sb.Dy())*scale)
+	offset := image.Pt((width-tw)/2, (height-th)/2)
+	target := image.Rect(offset.X, offset.Y, offset.X+tw, offset.Y+th)
+	draw.ApproxBiLinear.Scale(dst, target, src, sb, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: frameQuality}); err != nil {
+		return nil, fmt.Errorf("error encoding frame: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// blankFrame returns a black JPEG used when no placeholder is available.
+func blankFrame(width, height int) []byte {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, nil)
+	return buf.Bytes()
+}
+
+// placeholderFrame loads the placeholder image sized for the given stream
+// parameters, falling back to a blank frame.
+func (s *Streamer) placeholderFrame(params EncodingParams) []byte {
+	width, height, _ := ParseResolution(params.Resolution)
+	data, err := os.ReadFile(s.placeholderImg)
+	if err != nil {
+		log.Printf("Error reading placeholder image %s: %v", s.placeholderImg, err)
+		return blankFrame(width, height)
+	}
+	frame, err := encodeFrame(data, width, height)
+	if err != nil {
+		log.Printf("Error preparing placeholder image %s: %v", s.placeholderImg, err)
+		return blankFrame(width, height)
+	}
+	return frame
+}
+
+// setFrame replaces the frame held by the stream and hands it to MJPEG
+// viewers.
+func (p *StreamProcess) setFrame(frame []byte) {
+	p.frameMu.Lock()
+	p.frame = frame
+	p.frameAt = time.Now()
+	p.frameMu.Unlock()
+	p.frames.publish(frame)
+}
+
+// heldFrame returns the frame currently held by the stream.
+func (p *StreamProcess) heldFrame() []byte {
+	p.frameMu.Lock()
+	defer p.frameMu.Unlock()
+	return p.frame
+}
+
+// fifoWriter is the write end of an encoder's input FIFO.
+type fifoWriter struct {
+	mu   sync.Mutex
+	path string
+	file *os.File    // nil while the encoder is down
+	run  *encoderRun // the encoder reading the FIFO
+}
+
+// open installs the write end of a freshly opened FIFO read by run.
+func (w *fifoWriter) open(path string, file *os.File, run *encoderRun) {
+	w.mu.Lock()
+	w.path, w.file, w.run = path, file, run
+	w.mu.Unlock()
+}
+
+// write writes a single frame into the FIFO. A failed or timed-out write
+// may leave part of the frame in the pipe, after which the encoder can no
+// longer tell where frames start, so the FIFO is closed and the encoder
+// killed for its supervisor to restart it with a fresh one.
+func (w *fifoWriter) write(frame []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return errEncoderDown
+	}
+	start := time.Now()
+	if err := w.file.SetWriteDeadline(start.Add(frameWriteTimeout)); err != nil {
+		return fmt.Errorf("error setting FIFO write deadline: %v", err)
+	}
+	if _, err := w.file.Write(frame); err != nil {
+		run := w.run
+		w.closeLocked()
+		if run != nil {
+			run.cmd.Process.Kill()
+		}
+		return fmt.Errorf("error writing frame to FIFO, restarting the encoder: %v", err)
+	}
+	metrics.FIFOWriteSeconds.Observe(time.Since(start).Seconds())
+	return nil
+}
+
+// close closes the FIFO, if open.
+func (w *fifoWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closeLocked()
+}
+
+func (w *fifoWriter) closeLocked() {
+	if w.file == nil {
+		return
+	}
+	if err := w.file.Close(); err != nil {
+		log.Printf("Error closing FIFO %s: %v", w.path, err)
+	}
+	w.file, w.run = nil, nil
+}
+
+// Snapshot returns the JPEG frame a stream currently shows and when it was
+// set.
+func (s *Streamer) Snapshot(streamID string) ([]byte, time.Time, error) {
+	s.mu.Lock()
+	process, exists := s.activeStreams[streamID]
+	s.mu.Unlock()
+	if !exists {
+		return nil, time.Time{}, ErrStreamNotFound
+	}
+
+	process.frameMu.Lock()
+	defer process.frameMu.Unlock()
+	if process.frame == nil {
+		return nil, time.Time{}, ErrStreamNotFound
+	}
+	return process.frame, process.frameAt, nil
+}
+
+// runFrameClock feeds the held frame into an encoder's FIFO at the stream's
+// frame rate until the stream is stopped, so that FFmpeg keeps producing
+// segments no matter how irregularly new images arrive. Each encoder of a
+// stream gets its own clock, so a stalled one does not hold back the others.
+func (s *Streamer) runFrameClock(streamID string, process *StreamProcess, fifo *fifoWriter) {
+	ticker := time.NewTicker(time.Second / time.Duration(process.params.FrameRate))
+	defer ticker.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case <-process.stopChan:
+			log.Printf("Stopping frame clock for stream %s", streamID)
+			return
+		case <-ticker.C:
+			err := fifo.write(process.heldFrame())
+			if err != nil && lastErr == nil {
+				log.Printf("Frame clock for stream %s: %v", streamID, err)
+			} else if err == nil && lastErr != nil {
+				log.Printf("Frame clock for stream %s recovered", streamID)
+			}
+			lastErr = err
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package streamer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxRenditions bounds the size of a rendition ladder.
+	maxRenditions = 5
+	// masterPlaylist is the name of the master playlist of a stream encoded
+	// as a rendition ladder.
+	masterPlaylist = "master.m3u8"
+	// mediaPlaylist is the name of the playlist of a single rendition.
+	mediaPlaylist = "stream.m3u8"
+)
+
+// renditionName restricts rendition names to safe directory names.
+var renditionName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Rendition is one variant of an adaptive bitrate ladder.
+type Rendition struct {
+	Name       string `json:"name,omitempty"`
+	Resolution string `json:"resolution"`
+	Bitrate    string `json:"bitrate"`
+}
+
+// ParseRenditions parses a ladder such as "1280x720@2500k,640x360@800k".
+func ParseRenditions(ladder string) ([]Rendition, error) {
+	var renditions []Rendition
+	for _, entry := range strings.Split(ladder, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		resolution, bitrate, ok := strings.Cut(entry, "@")
+		if !ok {
+			return nil, fmt.Errorf("invalid rendition %q, expected WIDTHxHEIGHT@BITRATE", entry)
+		}
+		renditions = append(renditions, Rendition{Resolution: resolution, Bitrate: bitrate})
+	}
+	return renditions, nil
+}
+
+// nameRenditions returns a copy of a ladder in which unnamed renditions are
+// named after their height, e.g. "720p".
+func nameRenditions(renditions []Rendition) []Rendition {
+	named := append([]Rendition(nil), renditions...)
+	for i, r := range named {
+		if r.Name != "" {
+			continue
+		}
+		if _, height, err := ParseResolution(r.Resolution); err == nil {
+			named[i].Name = fmt.Sprintf("%dp", height)
+		}
+	}
+	return named
+}
+
+// validateRenditions checks a ladder against the limits.
+func validateRenditions(renditions []Rendition, l Limits) error {
+	if len(renditions) > maxRenditions {
+		return fmt.Errorf("at most %d renditions are supported", maxRenditions)
+	}
+	seen := make(map[string]bool)
+	for i, r := range renditions {
+		width, height, err := ParseResolution(r.Resolution)
+		if err != nil {
+			return fmt.Errorf("rendition %d: %v", i, err)
+		}
+		if width > l.MaxWidth || height > l.MaxHeight {
+			return fmt.Errorf("rendition %d: resolution must not exceed %dx%d", i, l.MaxWidth, l.MaxHeight)
+		}
+		bitrate, err := ParseBitrate(r.Bitrate)
+		if err != nil {
+			return fmt.Errorf("rendition %d: %v", i, err)
+		}
+		if bitrate > l.MaxBitrate {
+			return fmt.Errorf("rendition %d: bitrate must not exceed %d bits/s", i, l.MaxBitrate)
+		}
+
+		if !renditionName.MatchString(r.Name) {
+			return fmt.Errorf("rendition %d: name may only contain letters, digits, '-' and '_'", i)
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("rendition %d: duplicate name %q", i, r.Name)
+		}
+		seen[r.Name] = true
+	}
+	return nil
+}
+
+// largestResolution returns the resolution of the rendition with the most
+// pixels, which is the size frames are held at.
+func largestResolution(renditions []Rendition) string {
+	best, bestPixels := "", 0
+	for _, r := range renditions {
+		width, height, err := ParseResolution(r.Resolution)
+		if err != nil {
+			continue
+		}
+		if pixels := width * height; pixels > bestPixels {
+			best, bestPixels = r.Resolution, pixels
+		}
+	}
+	return best
+}
+
+// Playlist returns the name of the HLS playlist players should open: the
+// master playlist for a ladder or a DASH stream, the media playlist
+// otherwise.
+func (p EncodingParams) Playlist() string {
+	if len(p.Renditions) > 0 || p.DASH {
+		return masterPlaylist
+	}
+	return mediaPlaylist
+}
+
+// outputPlaylist returns the name of the playlist FFmpeg maintains for a
+// stream, which tells whether an earlier run left output behind.
+func (p EncodingParams) outputPlaylist() string {
+	switch {
+	case p.LowLatency:
+		return partPlaylist
+	case p.DASH:
+		return dashManifest
+	}
+	return p.Playlist()
+}
+
+// h264Levels lists the H.264 levels FFmpeg may be asked to encode at, with
+// their macroblock rate, frame size and Main profile bitrate limits.
+var h264Levels = []struct {
+	level      string
+	idc        int
+	maxMBPS    int
+	maxFS      int
+	maxBitrate int64
+}{
+	{"3.0", 30, 40500, 1620, 10_000_000},
+	{"3.1", 31, 108000, 3600, 14_000_000},
+	{"3.2", 32, 216000, 5120, 20_000_000},
+	{"4.0", 40, 245760, 8192, 20_000_000},
+	{"4.1", 41, 245760, 8192, 50_000_000},
+	{"4.2", 42, 522240, 8704, 50_000_000},
+	{"5.0", 50, 589824, 22080, 135_000_000},
+	{"5.1", 51, 983040, 36864, 240_000_000},
+}
+
+// h264Level picks the lowest H.264 level able to carry a rendition and
+// returns it together with the matching RFC 6381 codec string for the Main
+// profile, e.g. "3.1" and "avc1.4d401f".
+func h264Level(width, height, fps int, bitrate int64) (string, string) {
+	frameSize := ((width + 15) / 16) * ((height + 15) / 16)
+	for _, l := range h264Levels {
+		if frameSize <= l.maxFS && frameSize*fps <= l.maxMBPS && bitrate <= l.maxBitrate {
+			return l.level, fmt.Sprintf("avc1.4d40%02x", l.idc)
+		}
+	}
+	last := h264Levels[len(h264Levels)-1]
+	return last.level, fmt.Sprintf("avc1.4d40%02x", last.idc)
+}
+
+// peakBandwidth estimates the BANDWIDTH of a rendition from its bitrate,
+// adding 10% for container overhead as FFmpeg does.
+func peakBandwidth(bitrate int64) int64 {
+	return bitrate + bitrate/10
+}
+
+// writeMasterPlaylist writes the master playlist describing every rendition
+// of a ladder.
+func writeMasterPlaylist(streamPath string, params EncodingParams) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, r := range params.Renditions {
+		width, height, err := ParseResolution(r.Resolution)
+		if err != nil {
+			return err
+		}
+		bitrate, err := ParseBitrate(r.Bitrate)
+		if err != nil {
+			return err
+		}
+		_, codecs := h264Level(width, height, params.FrameRate, bitrate)
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,RESOLUTION=%s,FRAME-RATE=%d.000,CODECS=\"%s\"\n",
+			peakBandwidth(bitrate), bitrate, r.Resolution, params.FrameRate, codecs)
+		fmt.Fprintf(&b, "%s/%s\n", r.Name, mediaPlaylist)
+	}
+
+	path := filepath.Join(streamPath, masterPlaylist)
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write master playlist: %v", err)
+	}
+	return nil
+}
+
+// ladderArgs returns the FFmpeg arguments encoding the input into every
+// rendition of a ladder, split from a single decoded stream. Keyframes are
+// aligned across renditions so players can switch at segment boundaries.
+func ladderArgs(params EncodingParams) ([]string, error) {
+	var filter strings.Builder
+	fmt.Fprintf(&filter, "[0:v]fps=%d,split=%d", params.FrameRate, len(params.Renditions))
+	for i := range params.Renditions {
+		fmt.Fprintf(&filter, "[in%d]", i)
+	}
+
+	var args []string
+	for i, r := range params.Renditions {
+		width, height, err := ParseResolution(r.Resolution)
+		if err != nil {
+			return nil, err
+		}
+		bitrate, err := ParseBitrate(r.Bitrate)
+		if err != nil {
+			return nil, err
+		}
+		level, _ := h264Level(width, height, params.FrameRate, bitrate)
+
+		fmt.Fprintf(&filter, ";[in%d]scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2[out%d]",
+			i, width, height, width, height, i)
+		n := strconv.Itoa(i)
+		args = append(args,
+			"-map", "[out"+n+"]",
+			"-b:v:"+n, r.Bitrate,
+			"-maxrate:v:"+n, r.Bitrate,
+			"-bufsize:v:"+n, r.Bitrate,
+			"-profile:v:"+n, "main",
+			"-level:v:"+n, level,
+		)
+	}
+
+	args = append([]string{"-filter_complex", filter.String()}, args...)
+	return append(args,
+		"-c:v", "libx264",
+		"-preset", params.Preset,
+		"-tune", "zerolatency",
+		"-g", strconv.Itoa(params.GOP),
+		"-keyint_min", strconv.Itoa(params.GOP),
+		"-sc_threshold", "0",
+		"-pix_fmt", "yuv420p",
+	), nil
+}
+
+// variantStreamMap returns the HLS muxer's var_stream_map for a ladder,
+// writing each rendition into a directory named after it.
+func variantStreamMap(streamPath string, params EncodingParams) (string, error) {
+	var streamMap []string
+	for i, r := range params.Renditions {
+		if err := os.MkdirAll(filepath.Join(streamPath, r.Name), 0755); err != nil {
+			return "", fmt.Errorf("failed to create rendition directory: %v", err)
+		}
+		streamMap = append(streamMap, fmt.Sprintf("v:%d,name:%s", i, r.Name))
+	}
+	return strings.Join(streamMap, " "), nil
+}
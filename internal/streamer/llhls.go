@@ -0,0 +1,417 @@
+package streamer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// partTarget is the preferred duration of an LL-HLS partial segment.
+	partTarget = 500 * time.Millisecond
+	// partPlaylist is the playlist FFmpeg maintains for the partial segments
+	// of a low-latency stream. Players are served a playlist derived from it.
+	partPlaylist = "parts.m3u8"
+	// partPattern and llSegmentPattern name partial and full segments.
+	partPattern      = "part%05d.m4s"
+	llSegmentPattern = "segment%05d.m4s"
+	// llSegmentsWithParts is how many of the most recent full segments keep
+	// their parts listed, covering the three target durations players may
+	// hold back.
+	llSegmentsWithParts = 3
+	// llPollInterval is how often a blocked request re-checks the playlist
+	// when no FFmpeg output wakes it earlier.
+	llPollInterval = 100 * time.Millisecond
+)
+
+var (
+	// ErrNotLowLatency is returned by the LL-HLS methods for streams that are
+	// not in low-latency mode.
+	ErrNotLowLatency = errors.New("stream is not in low-latency mode")
+	// ErrPlaylistTimeout is returned when a blocking playlist or part request
+	// is not satisfied in time.
+	ErrPlaylistTimeout = errors.New("timed out waiting for the requested part")
+	// ErrTooFarAhead is returned for blocking requests more than two segments
+	// past the end of the playlist.
+	ErrTooFarAhead = errors.New("requested segment is too far ahead of the live edge")
+	// ErrPartOutOfRange is returned for blocking requests naming a part
+	// index past the number of parts in a segment.
+	ErrPartOutOfRange = errors.New("requested part is out of range")
+)
+
+// partLayout describes how a low-latency stream is cut into parts: every
+// segment holds partsPerSegment parts of partFrames frames each.
+type partLayout struct {
+	partFrames      int
+	partsPerSegment int
+}
+
+// lowLatencyLayout picks the part size closest to partTarget that divides a
+// segment into whole frames.
+func lowLatencyLayout(params EncodingParams) partLayout {
+	segmentFrames := params.FrameRate * params.HLSTime
+	want := int(math.Round(partTarget.Seconds() * float64(params.FrameRate)))
+	if want < 1 {
+		want = 1
+	}
+	partFrames := 1
+	for n := 1; n <= want; n++ {
+		if segmentFrames%n == 0 {
+			partFrames = n
+		}
+	}
+	return partLayout{partFrames: partFrames, partsPerSegment: segmentFrames / partFrames}
+}
+
+// partDuration returns the duration of a part in seconds.
+func (l partLayout) partDuration(fps int) float64 {
+	return float64(l.partFrames) / float64(fps)
+}
+
+// lowLatencyArgs returns the FFmpeg arguments writing a low-latency stream
+// as fMP4 parts. Keyframes are placed at the start of every segment so the
+// first part of each segment is independent.
+func lowLatencyArgs(streamPath string, params EncodingParams, discontinuity bool) []string {
+	layout := lowLatencyLayout(params)
+	gop := strconv.Itoa(layout.partFrames * layout.partsPerSegment)
+	// Parts are cut by time rather than at keyframes. A restarted encoder
+	// starts a fresh part playlist at the next segment boundary instead of
+	// appending, so parts stay aligned with segments.
+	hlsFlags := "delete_segments+split_by_time"
+	if discontinuity {
+		hlsFlags += "+discont_start"
+	}
+	return []string{
+		"-c:v", "libx264",
+		"-preset", params.Preset,
+		"-tune", "zerolatency",
+		"-vf", fmt.Sprintf("fps=%d", params.FrameRate),
+		"-g", gop,
+		"-keyint_min", gop,
+		"-sc_threshold", "0",
+		"-pix_fmt", "yuv420p",
+		"-s", params.Resolution,
+		"-b:v", params.Bitrate,
+		"-maxrate", params.Bitrate,
+		"-bufsize", params.Bitrate,
+		"-f", "hls",
+		"-hls_segment_type", "fmp4",
+		"-hls_fmp4_init_filename", "init.mp4",
+		"-hls_time", strconv.FormatFloat(layout.partDuration(params.FrameRate), 'f', -1, 64),
+		"-hls_list_size", strconv.Itoa((params.HLSListSize + 1) * layout.partsPerSegment),
+		"-hls_flags", hlsFlags,
+		"-start_number", strconv.Itoa(nextPartNumber(streamPath, params)),
+		"-hls_segment_filename", filepath.Join(streamPath, partPattern),
+		filepath.Join(streamPath, partPlaylist),
+	}
+}
+
+// nextPartNumber returns the number the next encoder run of a low-latency
+// stream should start its parts at: the first segment boundary after the
+// parts already listed, so restarts keep parts aligned with segments.
+func nextPartNumber(streamPath string, params EncodingParams) int {
+	pl, err := readPartPlaylist(filepath.Join(streamPath, partPlaylist))
+	if err != nil || len(pl.parts) == 0 {
+		return 0
+	}
+	pps := lowLatencyLayout(params).partsPerSegment
+	next := pl.parts[len(pl.parts)-1].seq + 1
+	return (next + pps - 1) / pps * pps
+}
+
+// llPart is a partial segment listed in the part playlist.
+type llPart struct {
+	seq           int
+	duration      float64
+	uri           string
+	discontinuity bool
+}
+
+// partPlaylistData is the parsed part playlist written by FFmpeg.
+type partPlaylistData struct {
+	initURI string
+	parts   []llPart
+}
+
+// readPartPlaylist parses the part playlist written by FFmpeg.
+func readPartPlaylist(path string) (partPlaylistData, error) {
+	var pl partPlaylistData
+	f, err := os.Open(path)
+	if err != nil {
+		return pl, err
+	}
+	defer f.Close()
+
+	var duration float64
+	discontinuity := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			if _, uri, ok := strings.Cut(line, `URI="`); ok {
+				pl.initURI, _, _ = strings.Cut(uri, `"`)
+			}
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration, _ = strconv.ParseFloat(value, 64)
+		case line == "#EXT-X-DISCONTINUITY":
+			discontinuity = true
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			var seq int
+			if _, err := fmt.Sscanf(line, partPattern, &seq); err != nil {
+				continue
+			}
+			pl.parts = append(pl.parts, llPart{seq: seq, duration: duration, uri: line, discontinuity: discontinuity})
+			discontinuity = false
+		}
+	}
+	return pl, scanner.Err()
+}
+
+// lastPart returns the sequence number of the newest complete part, or -1.
+func (pl partPlaylistData) lastPart() int {
+	if len(pl.parts) == 0 {
+		return -1
+	}
+	return pl.parts[len(pl.parts)-1].seq
+}
+
+// render builds the LL-HLS media playlist exposed to players. Parts are
+// grouped into full segments of pps parts; a leading group missing parts is
+// left out.
+func (pl partPlaylistData) render(params EncodingParams, layout partLayout) string {
+	pps := layout.partsPerSegment
+	partDuration := layout.partDuration(params.FrameRate)
+
+	parts := pl.parts
+	for len(parts) > 0 && parts[0].seq%pps != 0 {
+		parts = parts[1:]
+	}
+
+	var groups [][]llPart
+	for _, p := range parts {
+		if p.seq%pps == 0 || len(groups) == 0 {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], p)
+	}
+	complete := len(groups)
+	if complete > 0 && len(groups[complete-1]) < pps {
+		complete--
+	}
+
+	targetDuration := params.HLSTime
+	for _, g := range groups[:complete] {
+		var total float64
+		for _, p := range g {
+			total += p.duration
+		}
+		if d := int(math.Ceil(total - 0.001)); d > targetDuration {
+			targetDuration = d
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:9\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", targetDuration)
+	fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partDuration)
+	fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*partDuration)
+	msn := 0
+	if len(groups) > 0 {
+		msn = groups[0][0].seq / pps
+	}
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", msn)
+	if pl.initURI != "" {
+		fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s\"\n", pl.initURI)
+	}
+
+	for i, g := range groups {
+		if g[0].discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if i >= complete-llSegmentsWithParts {
+			for _, p := range g {
+				fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%.3f,URI=\"%s\"", p.duration, p.uri)
+				if p.seq%pps == 0 {
+					b.WriteString(",INDEPENDENT=YES")
+				}
+				b.WriteString("\n")
+			}
+		}
+		if i < complete {
+			var total float64
+			for _, p := range g {
+				total += p.duration
+			}
+			fmt.Fprintf(&b, "#EXTINF:%.3f,\n", total)
+			fmt.Fprintf(&b, llSegmentPattern+"\n", g[0].seq/pps)
+		}
+	}
+	fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\""+partPattern+"\"\n", pl.lastPart()+1)
+	return b.String()
+}
+
+// partSignal wakes requests waiting for a low-latency stream to advance.
+type partSignal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newPartSignal() *partSignal {
+	return &partSignal{ch: make(chan struct{})}
+}
+
+// wait returns a channel closed on the next notify.
+func (s *partSignal) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ch
+}
+
+func (s *partSignal) notify() {
+	s.mu.Lock()
+	close(s.ch)
+	s.ch = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// LowLatency reports whether a stream is a running low-latency stream.
+func (s *Streamer) LowLatency(streamID string) bool {
+	_, err := s.lowLatencyProcess(streamID)
+	return err == nil
+}
+
+// lowLatencyProcess returns the process of a low-latency stream.
+func (s *Streamer) lowLatencyProcess(streamID string) (*StreamProcess, error) {
+	s.mu.Lock()
+	process, exists := s.activeStreams[streamID]
+	s.mu.Unlock()
+	if !exists {
+		return nil, ErrStreamNotFound
+	}
+	if !process.params.LowLatency {
+		return nil, ErrNotLowLatency
+	}
+	return process, nil
+}
+
+// waitForPart blocks until the part with sequence number seq is complete,
+// returning the part playlist at that point. It gives up after three target
+// durations or when ctx is done.
+func (s *Streamer) waitForPart(ctx context.Context, streamID string, process *StreamProcess, seq int) (partPlaylistData, error) {
+	path := filepath.Join(s.StreamDir(streamID), partPlaylist)
+	timeout := time.NewTimer(3 * time.Duration(process.params.HLSTime) * time.Second)
+	defer timeout.Stop()
+	pps := lowLatencyLayout(process.params).partsPerSegment
+
+	for {
+		wake := process.parts.wait()
+		pl, err := readPartPlaylist(path)
+		if err != nil && !os.IsNotExist(err) {
+			return pl, err
+		}
+		last := pl.lastPart()
+		if last >= seq {
+			return pl, nil
+		}
+		if seq/pps > last/pps+2 {
+			return pl, ErrTooFarAhead
+		}
+
+		select {
+		case <-ctx.Done():
+			return pl, ctx.Err()
+		case <-timeout.C:
+			return pl, ErrPlaylistTimeout
+		case <-wake:
+		case <-time.After(llPollInterval):
+		}
+	}
+}
+
+// LowLatencyPlaylist returns the LL-HLS media playlist of a stream. When msn
+// is not negative the call blocks until the playlist contains media segment
+// msn, or part part of it when part is not negative, as requested by the
+// _HLS_msn and _HLS_part delivery directives. Parts are numbered from 0
+// within each segment.
+func (s *Streamer) LowLatencyPlaylist(ctx context.Context, streamID string, msn, part int) ([]byte, error) {
+	process, err := s.lowLatencyProcess(streamID)
+	if err != nil {
+		return nil, err
+	}
+	layout := lowLatencyLayout(process.params)
+
+	var pl partPlaylistData
+	if msn < 0 {
+		pl, err = readPartPlaylist(filepath.Join(s.StreamDir(streamID), partPlaylist))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else {
+		// Without _HLS_part the whole segment has to be complete.
+		seq := (msn+1)*layout.partsPerSegment - 1
+		if part >= layout.partsPerSegment {
+			return nil, ErrPartOutOfRange
+		}
+		if part >= 0 {
+			seq = msn*layout.partsPerSegment + part
+		}
+		if pl, err = s.waitForPart(ctx, streamID, process, seq); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(pl.render(process.params, layout)), nil
+}
+
+// LowLatencyPart waits until a partial segment, such as one announced by a
+// preload hint, is complete and returns the path of its file.
+func (s *Streamer) LowLatencyPart(ctx context.Context, streamID, name string) (string, error) {
+	process, err := s.lowLatencyProcess(streamID)
+	if err != nil {
+		return "", err
+	}
+	var seq int
+	if _, err := fmt.Sscanf(name, partPattern, &seq); err != nil {
+		return "", fmt.Errorf("invalid part name %q", name)
+	}
+	if _, err := s.waitForPart(ctx, streamID, process, seq); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.StreamDir(streamID), name), nil
+}
+
+// LowLatencySegment returns the files of the parts making up a full segment
+// of a low-latency stream, in order. Concatenated, they form the segment.
+func (s *Streamer) LowLatencySegment(streamID string, msn int) ([]string, error) {
+	process, err := s.lowLatencyProcess(streamID)
+	if err != nil {
+		return nil, err
+	}
+	pps := lowLatencyLayout(process.params).partsPerSegment
+	streamPath := s.StreamDir(streamID)
+
+	pl, err := readPartPlaylist(filepath.Join(streamPath, partPlaylist))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, p := range pl.parts {
+		if p.seq/pps == msn {
+			files = append(files, filepath.Join(streamPath, p.uri))
+		}
+	}
+	if len(files) != pps {
+		return nil, os.ErrNotExist
+	}
+	return files, nil
+}
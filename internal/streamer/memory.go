@@ -0,0 +1,25 @@
+package streamer
+
+import "github.com/abaddouh/poll-streamer/internal/segstore"
+
+// inMemory reports whether a stream's HLS output goes to the in-memory
+// segment store. LL-HLS and DASH output is read back from disk by the
+// server, and RTMP-only streams produce none. DVR and EVENT playlists stay
+// on disk too, since the store evicts segments they must keep listing.
+func (s *Streamer) inMemory(params EncodingParams) bool {
+	return s.segments != nil && s.ingestURL != "" && !params.LowLatency && !params.DASH && !params.RTMPOnly &&
+		!params.Retains()
+}
+
+// ingestArgs returns the HLS muxer options uploading the output to the
+// segment store's ingest endpoint instead of writing files. Failed uploads
+// are logged rather than stopping the encoder, and deleted segments are
+// sent as DELETE requests.
+func (s *Streamer) ingestArgs() []string {
+	return []string{
+		"-method", "PUT",
+		"-http_persistent", "1",
+		"-ignore_io_errors", "1",
+		"-headers", segstore.TokenHeader + ": " + s.segments.Token() + "\r\n",
+	}
+}
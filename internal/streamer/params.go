@@ -0,0 +1,286 @@
+package streamer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// presets lists the libx264 presets a stream may request.
+var presets = []string{
+	"ultrafast", "superfast", "veryfast", "faster", "fast",
+	"medium", "slow", "slower", "veryslow",
+}
+
+// Segment formats a stream may be written in.
+const (
+	SegmentFormatMPEGTS = "mpegts"
+	SegmentFormatFMP4   = "fmp4"
+)
+
+// EncodingParams controls how a single stream is encoded. Zero values are
+// filled from the streamer defaults.
+type EncodingParams struct {
+	FrameRate   int    `json:"fps,omitempty"`
+	Resolution  string `json:"resolution,omitempty"`
+	Bitrate     string `json:"bitrate,omitempty"`
+	GOP         int    `json:"gop,omitempty"`
+	HLSTime     int    `json:"hls_time,omitempty"`
+	HLSListSize int    `json:"hls_list_size,omitempty"`
+	Preset      string `json:"preset,omitempty"`
+	// Renditions encodes the stream as an adaptive bitrate ladder instead of
+	// a single rendition at Resolution and Bitrate.
+	Renditions []Rendition `json:"renditions,omitempty"`
+	// LowLatency serves the stream as LL-HLS with partial segments and
+	// blocking playlist reload.
+	LowLatency bool `json:"low_latency,omitempty"`
+	// SegmentFormat is either SegmentFormatMPEGTS or SegmentFormatFMP4, the
+	// latter writing an init segment plus CMAF .m4s fragments.
+	SegmentFormat string `json:"segment_format,omitempty"`
+	// DASH also publishes the stream as MPEG-DASH, writing it with FFmpeg's
+	// DASH muxer.
+	DASH bool `json:"dash,omitempty"`
+	// RTMP pushes the stream to RTMPURL alongside HLS, or instead of it with
+	// RTMPOnly.
+	RTMP     bool   `json:"rtmp,omitempty"`
+	RTMPURL  string `json:"rtmp_url,omitempty"`
+	RTMPOnly bool   `json:"rtmp_only,omitempty"`
+	// Record archives every frame the stream receives, for later export.
+	Record bool `json:"record,omitempty"`
+	// DVRWindow keeps this many seconds of segments in the playlist so
+	// viewers can seek back, instead of HLSListSize segments.
+	DVRWindow int `json:"dvr_window,omitempty"`
+	// PlaylistType is PlaylistTypeEvent for a playlist that keeps every
+	// segment until the stream ends, or empty for a sliding live playlist.
+	PlaylistType string `json:"playlist_type,omitempty"`
+	// Publish uploads the HLS output to the configured bucket.
+	Publish bool `json:"publish,omitempty"`
+}
+
+// Limits bounds the encoding parameters clients may request.
+type Limits struct {
+	MaxFrameRate   int
+	MaxWidth       int
+	MaxHeight      int
+	MaxBitrate     int64
+	MaxGOP         int
+	MaxHLSTime     int
+	MaxHLSListSize int
+	MaxDVRWindow   int
+}
+
+// DefaultLimits returns the limits used when none are configured.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxFrameRate:   60,
+		MaxWidth:       1920,
+		MaxHeight:      1080,
+		MaxBitrate:     8_000_000,
+		MaxGOP:         600,
+		MaxHLSTime:     10,
+		MaxHLSListSize: 30,
+		MaxDVRWindow:   6 * 60 * 60,
+	}
+}
+
+// withDefaults returns p with every unset field taken from d.
+func (p EncodingParams) withDefaults(d EncodingParams) EncodingParams {
+	if p.RTMPURL != "" || p.RTMPOnly {
+		p.RTMP = true
+	}
+	if !p.RTMP {
+		p.RTMP = d.RTMP
+	}
+	if p.RTMP && p.RTMPURL == "" {
+		p.RTMPURL = d.RTMPURL
+	}
+	if p.RTMP && p.RTMPURL == "" {
+		p.RTMPURL = DefaultRTMPURL
+	}
+	if p.Renditions == nil && !p.LowLatency && !p.RTMPOnly {
+		p.Renditions = d.Renditions
+	}
+	p.Renditions = nameRenditions(p.Renditions)
+	if p.Resolution == "" && len(p.Renditions) > 0 {
+		p.Resolution = largestResolution(p.Renditions)
+	}
+	if p.FrameRate == 0 {
+		p.FrameRate = d.FrameRate
+	}
+	if p.Resolution == "" {
+		p.Resolution = d.Resolution
+	}
+	if p.Bitrate == "" {
+		p.Bitrate = d.Bitrate
+	}
+	if p.GOP == 0 {
+		p.GOP = d.GOP
+	}
+	if p.GOP == 0 {
+		p.GOP = p.FrameRate * 2
+	}
+	if p.HLSTime == 0 {
+		p.HLSTime = d.HLSTime
+	}
+	if p.HLSTime == 0 {
+		p.HLSTime = 2
+	}
+	if p.HLSListSize == 0 {
+		p.HLSListSize = d.HLSListSize
+	}
+	if p.HLSListSize == 0 {
+		p.HLSListSize = 5
+	}
+	if !p.DASH && !p.LowLatency && !p.RTMPOnly {
+		p.DASH = d.DASH
+	}
+	if (p.LowLatency || p.DASH) && p.SegmentFormat == "" {
+		// Partial segments and DASH segments are always fMP4.
+		p.SegmentFormat = SegmentFormatFMP4
+	}
+	if p.SegmentFormat == "" {
+		p.SegmentFormat = d.SegmentFormat
+	}
+	if p.SegmentFormat == "" {
+		p.SegmentFormat = SegmentFormatMPEGTS
+	}
+	if !p.Record {
+		p.Record = d.Record
+	}
+	if p.DVRWindow == 0 && p.PlaylistType == "" && !p.LowLatency && !p.DASH && !p.RTMPOnly {
+		p.DVRWindow = d.DVRWindow
+		p.PlaylistType = d.PlaylistType
+	}
+	if !p.Publish && !p.LowLatency && !p.DASH && !p.RTMPOnly {
+		p.Publish = d.Publish
+	}
+	if p.Preset == "" {
+		p.Preset = d.Preset
+	}
+	if p.Preset == "" {
+		p.Preset = "ultrafast"
+	}
+	return p
+}
+
+// Validate checks the parameters against the given limits.
+func (p EncodingParams) Validate(l Limits) error {
+	if p.FrameRate <= 0 || p.FrameRate > l.MaxFrameRate {
+		return fmt.Errorf("fps must be between 1 and %d", l.MaxFrameRate)
+	}
+
+	width, height, err := ParseResolution(p.Resolution)
+	if err != nil {
+		return err
+	}
+	if width > l.MaxWidth || height > l.MaxHeight {
+		return fmt.Errorf("resolution must not exceed %dx%d", l.MaxWidth, l.MaxHeight)
+	}
+
+	bitrate, err := ParseBitrate(p.Bitrate)
+	if err != nil {
+		return err
+	}
+	if bitrate > l.MaxBitrate {
+		return fmt.Errorf("bitrate must not exceed %d bits/s", l.MaxBitrate)
+	}
+
+	if p.GOP <= 0 || p.GOP > l.MaxGOP {
+		return fmt.Errorf("gop must be between 1 and %d", l.MaxGOP)
+	}
+	if p.HLSTime <= 0 || p.HLSTime > l.MaxHLSTime {
+		return fmt.Errorf("hls_time must be between 1 and %d", l.MaxHLSTime)
+	}
+	if p.HLSListSize <= 0 || p.HLSListSize > l.MaxHLSListSize {
+		return fmt.Errorf("hls_list_size must be between 1 and %d", l.MaxHLSListSize)
+	}
+
+	if err := validateRenditions(p.Renditions, l); err != nil {
+		return err
+	}
+	if p.LowLatency && len(p.Renditions) > 0 {
+		return fmt.Errorf("low_latency cannot be combined with renditions")
+	}
+	if p.LowLatency && p.DASH {
+		return fmt.Errorf("low_latency cannot be combined with dash")
+	}
+	if p.RTMP {
+		if err := validateRTMPURL(p.RTMPURL); err != nil {
+			return err
+		}
+	}
+	if p.RTMPOnly && (p.LowLatency || p.DASH || len(p.Renditions) > 0) {
+		return fmt.Errorf("rtmp_only cannot be combined with low_latency, dash or renditions")
+	}
+	if p.DVRWindow < 0 || p.DVRWindow > l.MaxDVRWindow {
+		return fmt.Errorf("dvr_window must be between 0 and %d seconds", l.MaxDVRWindow)
+	}
+	if p.PlaylistType != "" && p.PlaylistType != PlaylistTypeEvent {
+		return fmt.Errorf("playlist_type must be empty or %s", PlaylistTypeEvent)
+	}
+	if p.DVRWindow > 0 && p.PlaylistType == PlaylistTypeEvent {
+		return fmt.Errorf("dvr_window cannot be combined with playlist_type %s", PlaylistTypeEvent)
+	}
+	if p.Retains() && (p.LowLatency || p.DASH || p.RTMPOnly) {
+		return fmt.Errorf("dvr_window and playlist_type cannot be combined with low_latency, dash or rtmp_only")
+	}
+	if p.Publish && (p.LowLatency || p.DASH || p.RTMPOnly) {
+		return fmt.Errorf("publish cannot be combined with low_latency, dash or rtmp_only")
+	}
+	switch p.SegmentFormat {
+	case SegmentFormatMPEGTS:
+		if p.LowLatency {
+			return fmt.Errorf("low_latency requires segment_format %s", SegmentFormatFMP4)
+		}
+		if p.DASH {
+			return fmt.Errorf("dash requires segment_format %s", SegmentFormatFMP4)
+		}
+	case SegmentFormatFMP4:
+	default:
+		return fmt.Errorf("segment_format must be %s or %s", SegmentFormatMPEGTS, SegmentFormatFMP4)
+	}
+
+	for _, preset := range presets {
+		if p.Preset == preset {
+			return nil
+		}
+	}
+	return fmt.Errorf("preset must be one of %s", strings.Join(presets, ", "))
+}
+
+// ParseResolution parses a WIDTHxHEIGHT string.
+func ParseResolution(resolution string) (int, int, error) {
+	w, h, ok := strings.Cut(resolution, "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid resolution %q, expected WIDTHxHEIGHT", resolution)
+	}
+	width, err := strconv.Atoi(w)
+	if err != nil || width <= 0 || width%2 != 0 {
+		return 0, 0, fmt.Errorf("invalid resolution %q, width must be a positive even number", resolution)
+	}
+	height, err := strconv.Atoi(h)
+	if err != nil || height <= 0 || height%2 != 0 {
+		return 0, 0, fmt.Errorf("invalid resolution %q, height must be a positive even number", resolution)
+	}
+	return width, height, nil
+}
+
+// ParseBitrate parses an FFmpeg style bitrate such as "500k" or "2M" into
+// bits per second.
+func ParseBitrate(bitrate string) (int64, error) {
+	multiplier := int64(1)
+	number := bitrate
+	switch {
+	case strings.HasSuffix(bitrate, "k"), strings.HasSuffix(bitrate, "K"):
+		multiplier = 1_000
+		number = bitrate[:len(bitrate)-1]
+	case strings.HasSuffix(bitrate, "M"), strings.HasSuffix(bitrate, "m"):
+		multiplier = 1_000_000
+		number = bitrate[:len(bitrate)-1]
+	}
+	value, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid bitrate %q, expected a value such as 500k or 2M", bitrate)
+	}
+	return value * multiplier, nil
+}
@@ -0,0 +1,210 @@
+package streamer
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// stderrLines is how many recent FFmpeg stderr lines are kept per stream.
+	stderrLines = 100
+	// maxLineLength truncates overly long output lines.
+	maxLineLength = 1024
+)
+
+// EncoderStats is the latest progress report of a stream's encoder, parsed
+// from FFmpeg's -progress output.
+type EncoderStats struct {
+	Frame         int64     `json:"frame"`
+	FPS           float64   `json:"fps"`
+	BitrateKbps   float64   `json:"bitrate_kbps"`
+	Speed         float64   `json:"speed"`
+	DroppedFrames int64     `json:"drop_frames"`
+	DupFrames     int64     `json:"dup_frames"`
+	TotalSize     int64     `json:"total_size"`
+	OutTime       string    `json:"out_time"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// EncoderStatus combines the progress statistics and recent stderr output of
+// a stream's encoder.
+type EncoderStatus struct {
+	StreamID string        `json:"stream_id"`
+	State    string        `json:"state"`
+	PID      int           `json:"pid,omitempty"`
+	Restarts int           `json:"restarts"`
+	Stats    *EncoderStats `json:"stats,omitempty"`
+	Stderr   []string      `json:"stderr"`
+}
+
+// lineWriter is an io.Writer that splits its input into lines, treating
+// carriage returns as line breaks, and hands each one to emit.
+type lineWriter struct {
+	mu      sync.Mutex
+	partial []byte
+	last    string
+	emit    func(line string)
+}
+
+func newLineWriter(emit func(line string)) *lineWriter {
+	return &lineWriter{emit: emit}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, b := range p {
+		if b == '\n' || b == '\r' {
+			w.flush()
+			continue
+		}
+		if len(w.partial) < maxLineLength {
+			w.partial = append(w.partial, b)
+		}
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) flush() {
+	line := strings.TrimSpace(string(w.partial))
+	w.partial = w.partial[:0]
+	if line == "" {
+		return
+	}
+	w.last = line
+	w.emit(line)
+}
+
+// lastLine returns the last complete line written.
+func (w *lineWriter) lastLine() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.last
+}
+
+// lineRing keeps the most recent lines written to it.
+type lineRing struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func newLineRing(size int) *lineRing {
+	return &lineRing{lines: make([]string, size)}
+}
+
+func (r *lineRing) add(line string) {
+	r.mu.Lock()
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+	r.mu.Unlock()
+}
+
+// snapshot returns the buffered lines, oldest first.
+func (r *lineRing) snapshot() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append(make([]string, 0, r.next), r.lines[:r.next]...)
+	}
+	out := make([]string, 0, len(r.lines))
+	out = append(out, r.lines[r.next:]...)
+	return append(out, r.lines[:r.next]...)
+}
+
+// progressParser accumulates FFmpeg -progress key=value lines and publishes
+// a complete EncoderStats at the end of every block.
+type progressParser struct {
+	pending EncoderStats
+	publish func(EncoderStats)
+}
+
+func (p *progressParser) line(line string) {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return
+	}
+	value = strings.TrimSpace(value)
+	switch key {
+	case "frame":
+		p.pending.Frame, _ = strconv.ParseInt(value, 10, 64)
+	case "fps":
+		p.pending.FPS, _ = strconv.ParseFloat(value, 64)
+	case "bitrate":
+		p.pending.BitrateKbps, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+	case "speed":
+		p.pending.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	case "drop_frames":
+		p.pending.DroppedFrames, _ = strconv.ParseInt(value, 10, 64)
+	case "dup_frames":
+		p.pending.DupFrames, _ = strconv.ParseInt(value, 10, 64)
+	case "total_size":
+		p.pending.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+	case "out_time":
+		p.pending.OutTime = value
+	case "progress":
+		p.pending.UpdatedAt = time.Now()
+		p.publish(p.pending)
+		p.pending = EncoderStats{}
+	}
+}
+
+// segmentOpened reports whether an FFmpeg log line announces a new media
+// segment, e.g. "[hls @ 0x55d0] Opening 'segment004.ts' for writing".
+func segmentOpened(line string) bool {
+	if !strings.Contains(line, "Opening '") || !strings.HasSuffix(line, "' for writing") {
+		return false
+	}
+	name := strings.TrimSuffix(line, "' for writing")
+	return strings.HasSuffix(name, ".ts") || strings.HasSuffix(name, ".m4s")
+}
+
+// setStats records the latest progress report of a stream's encoder.
+func (p *StreamProcess) setStats(stats EncoderStats) {
+	p.statsMu.Lock()
+	p.stats = &stats
+	p.statsMu.Unlock()
+}
+
+// EncoderStatus reports the progress statistics and recent stderr output of
+// a stream's encoder.
+func (s *Streamer) EncoderStatus(streamID string) (EncoderStatus, error) {
+	s.mu.Lock()
+	process, exists := s.activeStreams[streamID]
+	if !exists {
+		s.mu.Unlock()
+		return EncoderStatus{}, ErrStreamNotFound
+	}
+	status := EncoderStatus{
+		StreamID: streamID,
+		State:    process.state,
+		Restarts: process.restarts,
+	}
+	if process.run != nil && process.state == StateRunning {
+		status.PID = process.run.cmd.Process.Pid
+	}
+	s.mu.Unlock()
+
+	process.statsMu.Lock()
+	if process.stats != nil {
+		stats := *process.stats
+		status.Stats = &stats
+	}
+	process.statsMu.Unlock()
+
+	status.Stderr = process.stderr.snapshot()
+	if target := process.params.RTMPTarget(streamID); target != "" {
+		// FFmpeg names its output, the push target of RTMP-only streams.
+		redacted := RedactRTMPURL(target)
+		for i, line := range status.Stderr {
+			status.Stderr[i] = strings.ReplaceAll(line, target, redacted)
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,160 @@
+package streamer
+
+import (
+	"context"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// publishInterval is how often the playlists of a published stream are
+	// checked for changes.
+	publishInterval = 500 * time.Millisecond
+	// unpublishTimeout bounds the removal of a stopped stream from the
+	// bucket.
+	unpublishTimeout = 2 * time.Minute
+)
+
+// PublishStatus reports the state of a stream's publishing to the bucket.
+type PublishStatus struct {
+	URL         string     `json:"url"`
+	SyncedAt    *time.Time `json:"synced_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// publishState tracks the publishing loop of a stream.
+type publishState struct {
+	done chan struct{}
+
+	mu          sync.Mutex
+	failing     bool
+	syncedAt    time.Time
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// PublicURL returns the bucket URL of a stream's playlist, or "" if the
+// stream is not published.
+func (s *Streamer) PublicURL(streamID string, params EncodingParams) string {
+	if !params.Publish || s.publisher == nil {
+		return ""
+	}
+	return s.publisher.URL(streamID, params.Playlist())
+}
+
+// publishStatus reports the state of a stream's publishing.
+func (s *Streamer) publishStatus(streamID string, process *StreamProcess) *PublishStatus {
+	state := process.publish
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	status := &PublishStatus{
+		URL:       s.PublicURL(streamID, process.params),
+		LastError: state.lastError,
+	}
+	if !state.syncedAt.IsZero() {
+		syncedAt := state.syncedAt
+		status.SyncedAt = &syncedAt
+	}
+	if !state.lastErrorAt.IsZero() {
+		lastErrorAt := state.lastErrorAt
+		status.LastErrorAt = &lastErrorAt
+	}
+	return status
+}
+
+// mediaPlaylists returns the media playlists of a stream, relative to its
+// output, and its master playlist if it has one.
+func mediaPlaylists(params EncodingParams) ([]string, string) {
+	if len(params.Renditions) == 0 {
+		return []string{mediaPlaylist}, ""
+	}
+	playlists := make([]string, 0, len(params.Renditions))
+	for _, r := range params.Renditions {
+		playlists = append(playlists, path.Join(r.Name, mediaPlaylist))
+	}
+	return playlists, masterPlaylist
+}
+
+// readOutput returns a file of a stream's output from the segment store or
+// the stream directory.
+func (s *Streamer) readOutput(streamID, name string) ([]byte, error) {
+	if s.segments != nil {
+		if f, ok := s.segments.Get(streamID, name); ok {
+			return f.Data, nil
+		}
+	}
+	if strings.HasSuffix(name, ".m3u8") {
+		return s.ReadPlaylist(streamID, name)
+	}
+	return os.ReadFile(filepath.Join(s.StreamDir(streamID), filepath.FromSlash(name)))
+}
+
+// runPublisher keeps the bucket copy of a stream up to date until the
+// stream is stopped. Failures are retried on the next tick.
+func (s *Streamer) runPublisher(streamID string, process *StreamProcess) {
+	state := process.publish
+	defer close(state.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		// Abort uploads in flight when the stream stops.
+		select {
+		case <-process.stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	playlists, master := mediaPlaylists(process.params)
+	read := func(name string) ([]byte, error) {
+		return s.readOutput(streamID, name)
+	}
+	ticker := time.NewTicker(publishInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-process.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		err := s.publisher.Sync(ctx, streamID, read, playlists, master)
+		if ctx.Err() != nil {
+			return
+		}
+		state.mu.Lock()
+		if err != nil {
+			if !state.failing {
+				log.Printf("Error publishing stream %s: %v", streamID, err)
+			}
+			state.failing = true
+			state.lastError = err.Error()
+			state.lastErrorAt = time.Now()
+		} else {
+			if state.failing {
+				log.Printf("Publishing stream %s recovered", streamID)
+			}
+			state.failing = false
+			state.syncedAt = time.Now()
+		}
+		state.mu.Unlock()
+	}
+}
+
+// unpublish removes a stopped stream from the bucket in the background.
+func (s *Streamer) unpublish(streamID string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), unpublishTimeout)
+		defer cancel()
+		if err := s.publisher.DeleteStream(ctx, streamID); err != nil {
+			log.Printf("Error removing stream %s from the bucket: %v", streamID, err)
+		}
+	}()
+}
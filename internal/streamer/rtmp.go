@@ -0,0 +1,246 @@
+package streamer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRTMPURL is the push target of streams that enable RTMP without
+	// a URL: the nginx-rtmp sidecar. {stream_id} is replaced with the ID of
+	// the stream.
+	DefaultRTMPURL = "rtmp://127.0.0.1/live/{stream_id}"
+	// rtmpFIFO is the name of the FIFO the RTMP push encoder reads from.
+	rtmpFIFO = "rtmp_fifo"
+	// rtmpTimeout is how long FFmpeg waits on a stalled RTMP connection
+	// before giving up, in microseconds.
+	rtmpTimeout = "10000000"
+)
+
+// RTMPStatus reports the state of a stream's RTMP push.
+type RTMPStatus struct {
+	URL         string     `json:"url"`
+	State       string     `json:"state"`
+	Restarts    int        `json:"restarts"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// RTMPTarget returns the URL a stream is pushed to, or "" if it is not
+// pushed over RTMP.
+func (p EncodingParams) RTMPTarget(streamID string) string {
+	if !p.RTMP {
+		return ""
+	}
+	return strings.ReplaceAll(p.RTMPURL, "{stream_id}", streamID)
+}
+
+// Redacted returns a copy of the parameters that is safe to hand out
+// through the API, with the stream key of the push target hidden.
+func (p EncodingParams) Redacted() EncodingParams {
+	if p.RTMPURL != "" {
+		p.RTMPURL = RedactRTMPURL(p.RTMPURL)
+	}
+	return p
+}
+
+// RedactRTMPURL hides the credentials, path and query of a push target,
+// which carry the stream key on most platforms.
+func RedactRTMPURL(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return "xxxxx"
+	}
+	redacted := u.Scheme + "://" + u.Host
+	if strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+		redacted += "/xxxxx"
+	}
+	return redacted
+}
+
+// validateRTMPURL checks that a push target is an RTMP URL.
+func validateRTMPURL(target string) error {
+	u, err := url.Parse(strings.ReplaceAll(target, "{stream_id}", "id"))
+	if err != nil || (u.Scheme != "rtmp" && u.Scheme != "rtmps") || u.Host == "" {
+		return fmt.Errorf("rtmp_url must be an rtmp:// or rtmps:// URL")
+	}
+	return nil
+}
+
+// rtmpArgs returns the FFmpeg arguments encoding a single rendition at the
+// stream's resolution and bitrate and pushing it as FLV over RTMP.
+func rtmpArgs(params EncodingParams, target string) []string {
+	params.Renditions = nil
+	args, _ := encodeArgs(params)
+	return append(args,
+		"-f", "flv",
+		"-flvflags", "no_duration_filesize",
+		"-rw_timeout", rtmpTimeout,
+		target,
+	)
+}
+
+// rtmpPusher relays a stream to an RTMP server with an FFmpeg of its own,
+// fed from a second FIFO, so an unreachable RTMP server never interrupts
+// the HLS output.
+type rtmpPusher struct {
+	url  string
+	fifo fifoWriter
+
+	mu          sync.Mutex
+	run         *encoderRun
+	state       string
+	restarts    int
+	lastError   string
+	lastErrorAt time.Time
+}
+
+func newRTMPPusher(target string) *rtmpPusher {
+	return &rtmpPusher{url: target, state: StateStarting}
+}
+
+// status reports the state of the push.
+func (r *rtmpPusher) status() *RTMPStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := &RTMPStatus{
+		URL:       RedactRTMPURL(r.url),
+		State:     r.state,
+		Restarts:  r.restarts,
+		LastError: r.lastError,
+	}
+	if !r.lastErrorAt.IsZero() {
+		lastErrorAt := r.lastErrorAt
+		status.LastErrorAt = &lastErrorAt
+	}
+	return status
+}
+
+// attach records a freshly started push encoder. It reports false if the
+// push was stopped meanwhile, in which case the caller must kill the run.
+func (r *rtmpPusher) attach(run *encoderRun, fifoPath string, fifoFile *os.File) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state == StateExited {
+		return false
+	}
+	r.run = run
+	r.state = StateRunning
+	r.fifo.open(fifoPath, fifoFile, run)
+	return true
+}
+
+// fail records why the push is down.
+func (r *rtmpPusher) fail(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state == StateExited {
+		return
+	}
+	r.state = StateRestarting
+	r.lastError = reason
+	r.lastErrorAt = time.Now()
+}
+
+// runRTMPPush keeps the RTMP push of a stream running until the stream is
+// stopped, reconnecting with exponential backoff whenever FFmpeg exits, for
+// instance because the RTMP server restarted.
+func (s *Streamer) runRTMPPush(streamID string, process *StreamProcess) {
+	pusher := process.rtmp
+	streamPath := s.StreamDir(streamID)
+	attempt := 0
+	for {
+		run, err := s.startRTMPPush(streamID, process)
+		if errors.Is(err, errStreamStopped) {
+			return
+		}
+		if err == nil {
+			log.Printf("Pushing stream %s to %s", streamID, RedactRTMPURL(pusher.url))
+
+			select {
+			case <-process.stopChan:
+				return
+			case <-run.done:
+			}
+			pusher.fifo.close()
+			if time.Since(run.startedAt) >= stableRunTime {
+				attempt = 0
+			}
+			err = errors.New(run.exitReason())
+		}
+		pusher.fail(err.Error())
+
+		delay := restartDelay(attempt)
+		attempt++
+		log.Printf("RTMP push for stream %s failed (%v), reconnecting in %v", streamID, err, delay)
+		select {
+		case <-process.stopChan:
+			return
+		case <-time.After(delay):
+		}
+
+		fifoPath := filepath.Join(streamPath, rtmpFIFO)
+		if err := os.Remove(fifoPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing FIFO %s: %v", fifoPath, err)
+		}
+		pusher.mu.Lock()
+		pusher.restarts++
+		pusher.mu.Unlock()
+	}
+}
+
+// startRTMPPush launches the FFmpeg pushing a stream over RTMP.
+func (s *Streamer) startRTMPPush(streamID string, process *StreamProcess) (*encoderRun, error) {
+	streamPath := s.StreamDir(streamID)
+	fifoPath, err := s.createFIFO(streamPath, rtmpFIFO)
+	if err != nil {
+		return nil, err
+	}
+	pusher := process.rtmp
+	args := append(inputArgs(fifoPath, process.params), rtmpArgs(process.params, pusher.url)...)
+	run, fifoFile, err := launchFFmpeg(streamPath+" (RTMP)", fifoPath, args, nil, func(string) {})
+	if err != nil {
+		return nil, err
+	}
+
+	if !pusher.attach(run, fifoPath, fifoFile) {
+		// The stream was stopped while FFmpeg was starting up.
+		fifoFile.Close()
+		run.cmd.Process.Kill()
+		<-run.done
+		return nil, errStreamStopped
+	}
+	return run, nil
+}
+
+// stop closes the FIFO of the push and interrupts its FFmpeg, killing it if
+// it does not exit within stopTimeout. The stream's stopChan must already be
+// closed.
+func (r *rtmpPusher) stop(streamID string) {
+	r.mu.Lock()
+	run := r.run
+	r.state = StateExited
+	r.mu.Unlock()
+	r.fifo.close()
+	if run == nil {
+		return
+	}
+
+	if err := run.cmd.Process.Signal(os.Interrupt); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		log.Printf("Error sending interrupt to RTMP push for %s: %v", streamID, err)
+	}
+	select {
+	case <-run.done:
+	case <-time.After(stopTimeout):
+		log.Printf("RTMP push for %s did not exit in time, killing it", streamID)
+		run.cmd.Process.Kill()
+		<-run.done
+	}
+}
@@ -1,53 +1,176 @@
 package streamer
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
+
+	"github.com/abaddouh/poll-streamer/internal/metrics"
+	"github.com/abaddouh/poll-streamer/internal/publisher"
+	"github.com/abaddouh/poll-streamer/internal/recorder"
+	"github.com/abaddouh/poll-streamer/internal/segstore"
+)
+
+// Stream states reported by StreamInfo.
+const (
+	StateStarting   = "starting"
+	StateRunning    = "running"
+	StateRestarting = "restarting"
+	StateExited     = "exited"
 )
 
+const (
+	// fifoOpenTimeout bounds how long we wait for FFmpeg to open its input FIFO.
+	fifoOpenTimeout = 10 * time.Second
+	// stopTimeout is how long FFmpeg gets to exit after an interrupt before it is killed.
+	stopTimeout = 5 * time.Second
+	// inputFIFO is the name of the FIFO the stream's encoder reads frames
+	// from.
+	inputFIFO = "input_fifo"
+)
+
+// ErrStreamNotFound is returned when an operation targets an unknown stream.
+var ErrStreamNotFound = errors.New("stream not found")
+
+// Config holds the settings shared by every stream of a Streamer.
+type Config struct {
+	OutputPath     string
+	PlaceholderImg string
+	Defaults       EncodingParams
+	Limits         Limits
+	// Recorder archives the frames of streams with Record set. Recording is
+	// unavailable if nil.
+	Recorder *recorder.Recorder
+	// Segments, if set, holds the HLS output of streams in memory. FFmpeg
+	// uploads it to IngestURL, the base URL of the store's ingest endpoint.
+	// LL-HLS, DASH and RTMP-only streams are unaffected.
+	Segments  *segstore.Store
+	IngestURL string
+	// Publisher uploads the output of streams with Publish set to a
+	// bucket. Publishing is unavailable if nil.
+	Publisher *publisher.Publisher
+}
+
 type Streamer struct {
 	outputPath     string
-	frameRate      int
-	resolution     string
-	bitrate        string
 	placeholderImg string
+	defaults       EncodingParams
+	limits         Limits
+	recorder       *recorder.Recorder
+	segments       *segstore.Store
+	ingestURL      string
+	publisher      *publisher.Publisher
 	activeStreams  map[string]*StreamProcess
 	mu             sync.Mutex
 }
 
 type StreamProcess struct {
-	cmd      *exec.Cmd
-	fifoFile *os.File
-	FIFOPath string
-	stopChan chan struct{}
+	run         *encoderRun
+	fifo        fifoWriter
+	stopChan    chan struct{}
+	frameMu     sync.Mutex
+	frame       []byte
+	frameAt     time.Time // when frame was set, guarded by frameMu
+	params      EncodingParams
+	state       string
+	createdAt   time.Time
+	lastFrame   time.Time
+	restarts    int
+	lastError   string
+	lastErrorAt time.Time
+	statsMu     sync.Mutex
+	stats       *EncoderStats
+	stderr      *lineRing
+	parts       *partSignal
+	rtmp        *rtmpPusher // nil unless pushing alongside HLS
+	frames      *frameBroadcaster
+	pruned      prunedSegments
+	publish     *publishState // nil unless publishing to a bucket
+}
+
+// encoderRun is a single invocation of FFmpeg for a stream.
+type encoderRun struct {
+	cmd       *exec.Cmd
+	startedAt time.Time
+	done      chan struct{}
+	err       error // valid once done is closed
+	stderr    *lineWriter
+}
+
+// exitReason describes why a finished run exited, including the last line
+// FFmpeg wrote to stderr.
+func (r *encoderRun) exitReason() string {
+	reason := "exited"
+	if r.err != nil {
+		reason = r.err.Error()
+	}
+	if last := r.stderr.lastLine(); last != "" {
+		reason += ": " + last
+	}
+	return reason
 }
 
-// type Stream struct {
-// 	// ... existing fields ...
-// 	FIFOPath string
-// }
+// StreamInfo is a point-in-time snapshot of a stream's encoder state.
+type StreamInfo struct {
+	ID           string         `json:"id"`
+	State        string         `json:"state"`
+	CreatedAt    time.Time      `json:"created_at"`
+	LastFrameAt  *time.Time     `json:"last_frame_at,omitempty"`
+	PID          int            `json:"pid,omitempty"`
+	SegmentCount int            `json:"segment_count"`
+	Restarts     int            `json:"restarts"`
+	LastError    string         `json:"last_error,omitempty"`
+	LastErrorAt  *time.Time     `json:"last_error_at,omitempty"`
+	RTMP         *RTMPStatus    `json:"rtmp,omitempty"`
+	Publish      *PublishStatus `json:"publish,omitempty"`
+	Params       EncodingParams `json:"params"`
+}
 
-func New(outputPath string, frameRate int, resolution, bitrate, placeholderImg string) *Streamer {
+func New(cfg Config) *Streamer {
 	return &Streamer{
-		outputPath:     outputPath,
-		frameRate:      frameRate,
-		resolution:     resolution,
-		bitrate:        bitrate,
-		placeholderImg: placeholderImg,
+		outputPath:     cfg.OutputPath,
+		placeholderImg: cfg.PlaceholderImg,
+		defaults:       cfg.Defaults,
+		limits:         cfg.Limits,
+		recorder:       cfg.Recorder,
+		segments:       cfg.Segments,
+		ingestURL:      cfg.IngestURL,
+		publisher:      cfg.Publisher,
 		activeStreams:  make(map[string]*StreamProcess),
 	}
 }
 
-func (s *Streamer) createFIFO(streamPath string) (string, error) {
-	fifoPath := filepath.Join(streamPath, "input_fifo")
+// ResolveParams fills unset fields of a stream's requested parameters from
+// the streamer defaults and validates the result against the limits.
+func (s *Streamer) ResolveParams(params EncodingParams) (EncodingParams, error) {
+	params = params.withDefaults(s.defaults)
+	if err := params.Validate(s.limits); err != nil {
+		return EncodingParams{}, err
+	}
+	if params.Record && s.recorder == nil {
+		return EncodingParams{}, errors.New("recording is not configured")
+	}
+	if params.Publish && s.publisher == nil {
+		return EncodingParams{}, errors.New("publishing is not configured")
+	}
+	return params, nil
+}
+
+// StreamDir returns the directory holding the HLS output of a stream.
+func (s *Streamer) StreamDir(streamID string) string {
+	return filepath.Join(s.outputPath, streamID)
+}
+
+func (s *Streamer) createFIFO(streamPath, name string) (string, error) {
+	fifoPath := filepath.Join(streamPath, name)
 	if _, err := os.Stat(fifoPath); os.IsNotExist(err) {
 		if err := syscall.Mkfifo(fifoPath, 0666); err != nil {
 			return "", fmt.Errorf("failed to create FIFO: %v", err)
@@ -56,186 +179,521 @@ func (s *Streamer) createFIFO(streamPath string) (string, error) {
 	return fifoPath, nil
 }
 
-func (s *Streamer) startPersistentFFmpeg(fifoPath string, streamID string) (*exec.Cmd, *os.File, error) {
-	streamPath := filepath.Join(s.outputPath, "stream", streamID)
-	cmd := exec.Command("ffmpeg",
+// openFIFOWriter opens the FIFO for writing once FFmpeg has opened it for
+// reading. The file is left non-blocking so writes honour deadlines.
+func openFIFOWriter(fifoPath string, done <-chan struct{}) (*os.File, error) {
+	deadline := time.Now().Add(fifoOpenTimeout)
+	for {
+		f, err := os.OpenFile(fifoPath, os.O_WRONLY|syscall.O_NONBLOCK, os.ModeNamedPipe)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, syscall.ENXIO) {
+			return nil, fmt.Errorf("failed to open FIFO for writing: %v", err)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for FFmpeg to open FIFO %s", fifoPath)
+		}
+		select {
+		case <-done:
+			return nil, fmt.Errorf("FFmpeg exited before opening FIFO %s", fifoPath)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func (s *Streamer) startPersistentFFmpeg(fifoPath string, streamID string, process *StreamProcess, discontinuity bool) (*encoderRun, *os.File, error) {
+	streamPath := s.StreamDir(streamID)
+	params := process.params
+	args := append([]string{"-progress", "pipe:1"}, inputArgs(fifoPath, params)...)
+	switch {
+	case params.RTMPOnly:
+		args = append(args, rtmpArgs(params, params.RTMPTarget(streamID))...)
+	case params.LowLatency:
+		args = append(args, lowLatencyArgs(streamPath, params, discontinuity)...)
+	case params.DASH:
+		output, err := dashArgs(streamPath, params)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, output...)
+	default:
+		base := streamPath
+		if s.inMemory(params) {
+			base = s.ingestURL + "/" + streamID
+			args = append(args, s.ingestArgs()...)
+		}
+		output, err := hlsArgs(streamPath, base, params, discontinuity)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, output...)
+	}
+
+	progress := &progressParser{publish: process.setStats}
+	return launchFFmpeg(streamPath, fifoPath, args, newLineWriter(progress.line), func(line string) {
+		process.stderr.add(line)
+		if strings.Contains(line, "Opening '") {
+			// FFmpeg moved on to a new file, so a part may have
+			// completed.
+			process.parts.notify()
+		}
+		if segmentOpened(line) {
+			metrics.SegmentsProduced.WithLabelValues(streamID).Inc()
+			metrics.LastSegmentTime.WithLabelValues(streamID).SetToCurrentTime()
+		}
+	})
+}
+
+// inputArgs returns the FFmpeg arguments reading JPEG frames from a FIFO.
+func inputArgs(fifoPath string, params EncodingParams) []string {
+	return []string{
 		"-y",
-		"-re",
+		"-nostats",
 		"-f", "image2pipe",
-		"-framerate", fmt.Sprintf("%d", s.frameRate),
+		"-c:v", "mjpeg",
+		"-framerate", fmt.Sprintf("%d", params.FrameRate),
 		"-i", fifoPath,
-		"-c:v", "libx264",
-		"-preset", "ultrafast",
-		"-tune", "zerolatency",
-		"-vf", fmt.Sprintf("fps=%d", s.frameRate),
-		"-g", fmt.Sprintf("%d", s.frameRate*2),
-		"-pix_fmt", "yuv420p",
-		"-s", s.resolution,
-		"-b:v", s.bitrate,
-		"-maxrate", s.bitrate,
-		"-bufsize", s.bitrate,
-		"-f", "hls",
-		"-hls_time", "2",
-		"-hls_list_size", "5",
-		"-hls_flags", "delete_segments+append_list",
-		"-hls_segment_filename", filepath.Join(streamPath, "segment%03d.ts"),
-		filepath.Join(streamPath, "stream.m3u8"),
-	)
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err := cmd.Start()
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to start FFmpeg: %v", err)
 	}
+}
 
-	// // Log FFmpeg output in real-time
-	// go func() {
-	// 	scanner := bufio.NewScanner(&stderr)
-	// 	for scanner.Scan() {
-	// 		log.Printf("FFmpeg [%s]: %s", streamPath, scanner.Text())
-	// 	}
-	// }()
+// launchFFmpeg starts FFmpeg, handing every line it writes to stderr to
+// onStderr, and opens the write end of the FIFO it reads its input from.
+// name identifies the run in logs.
+func launchFFmpeg(name, fifoPath string, args []string, stdout io.Writer, onStderr func(line string)) (*encoderRun, *os.File, error) {
+	cmd := exec.Command("ffmpeg", args...)
+	run := &encoderRun{
+		cmd:    cmd,
+		done:   make(chan struct{}),
+		stderr: newLineWriter(onStderr),
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = run.stderr
+	// Don't let a stray child holding the output pipes delay exit detection.
+	cmd.WaitDelay = time.Second
 
-	fifoFile, err := os.OpenFile(fifoPath, os.O_WRONLY, os.ModeNamedPipe)
+	err := cmd.Start()
 	if err != nil {
-		cmd.Process.Kill()
-		return nil, nil, fmt.Errorf("failed to open FIFO for writing: %v", err)
+		return nil, nil, fmt.Errorf("failed to start FFmpeg: %v", err)
 	}
+	run.startedAt = time.Now()
 
 	// Monitor FFmpeg process
 	go func() {
 		err := cmd.Wait()
 		if err != nil {
-			log.Printf("FFmpeg process for %s exited with error: %v\nOutput: %s", streamPath, err, stderr.String())
-			log.Printf("FFmpeg stdout: %s", stdout.String())
-			log.Printf("FFmpeg stderr: %s", stderr.String())
+			log.Printf("FFmpeg process for %s exited with error: %v, last output: %s", name, err, run.stderr.lastLine())
 		} else {
-			log.Printf("FFmpeg process for %s exited successfully.", streamPath)
+			log.Printf("FFmpeg process for %s exited successfully.", name)
 		}
-		s.mu.Lock()
-		delete(s.activeStreams, streamPath)
-		s.mu.Unlock()
+		run.err = err
+		close(run.done)
 	}()
 
-	return cmd, fifoFile, nil
+	fifoFile, err := openFIFOWriter(fifoPath, run.done)
+	if err != nil {
+		cmd.Process.Kill()
+		<-run.done
+		return nil, nil, fmt.Errorf("%v (%s)", err, run.exitReason())
+	}
+
+	return run, fifoFile, nil
 }
 
-func (s *Streamer) writeImageToFIFO(fifoFile *os.File, imagePath, streamID string) error {
-	imgFile, err := os.Open(imagePath)
-	if err != nil {
-		return fmt.Errorf("error opening image file %s: %v", imagePath, err)
+// encodeArgs returns the FFmpeg arguments encoding the input into either a
+// single rendition or every rendition of a ladder.
+func encodeArgs(params EncodingParams) ([]string, error) {
+	if len(params.Renditions) > 0 {
+		return ladderArgs(params)
 	}
-	defer imgFile.Close()
+	return []string{
+		"-c:v", "libx264",
+		"-preset", params.Preset,
+		"-tune", "zerolatency",
+		"-vf", fmt.Sprintf("fps=%d", params.FrameRate),
+		"-g", fmt.Sprintf("%d", params.GOP),
+		"-pix_fmt", "yuv420p",
+		"-s", params.Resolution,
+		"-b:v", params.Bitrate,
+		"-maxrate", params.Bitrate,
+		"-bufsize", params.Bitrate,
+	}, nil
+}
 
-	log.Printf("Writing image %s to FIFO", imagePath)
-	_, err = io.Copy(fifoFile, imgFile)
+// hlsArgs returns the FFmpeg arguments encoding a stream as HLS with MPEG-TS
+// or fMP4 segments, either as a single rendition or as a rendition ladder.
+// The output is written under output, which is either the stream directory
+// or the stream's URL on the segment store's ingest endpoint.
+func hlsArgs(streamPath, output string, params EncodingParams, discontinuity bool) ([]string, error) {
+	hlsFlags := "delete_segments+append_list"
+	if params.PlaylistType == PlaylistTypeEvent {
+		// EVENT playlists never drop segments.
+		hlsFlags = "append_list"
+	}
+	if discontinuity {
+		// Tell players the timeline restarts after an encoder restart.
+		hlsFlags += "+discont_start"
+	}
+
+	args, err := encodeArgs(params)
 	if err != nil {
-		return fmt.Errorf("error writing image to FIFO: %v", err)
+		return nil, err
 	}
-	log.Printf("Successfully wrote image to FIFO for stream %s", streamID)
-	return nil
+	args = append(args, "-f", "hls")
+	if params.PlaylistType == PlaylistTypeEvent {
+		args = append(args, "-hls_playlist_type", "event")
+	}
+
+	outputDir := output
+	segmentName := "segment%03d.ts"
+	initName := "init.mp4"
+	if len(params.Renditions) > 0 {
+		streamMap, err := variantStreamMap(streamPath, params)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-var_stream_map", streamMap)
+		// FFmpeg substitutes %v with the rendition name.
+		outputDir = output + "/%v"
+		initName = "init_%v.mp4"
+	}
+	if params.SegmentFormat == SegmentFormatFMP4 {
+		// The init segment is written next to each media playlist and
+		// referenced from it with EXT-X-MAP.
+		segmentName = "segment%03d.m4s"
+		args = append(args,
+			"-hls_segment_type", "fmp4",
+			"-hls_fmp4_init_filename", initName,
+		)
+	}
+	return append(args,
+		"-hls_time", fmt.Sprintf("%d", params.HLSTime),
+		"-hls_list_size", fmt.Sprintf("%d", params.playlistSegments()),
+		"-hls_flags", hlsFlags,
+		"-hls_segment_filename", outputDir+"/"+segmentName,
+		outputDir+"/"+mediaPlaylist,
+	), nil
 }
 
-func (s *Streamer) ProcessImage(streamID, imagePath string) error {
-	stream, exists := s.activeStreams[streamID]
-	if !exists {
-		return fmt.Errorf("stream %s does not exist", streamID)
+// StartStream creates the output directory and FIFO for a stream, launches
+// its FFmpeg encoder and starts the frame clock holding the placeholder
+// image. The params are expected to have been passed through ResolveParams.
+func (s *Streamer) StartStream(streamID string, params EncodingParams) error {
+	return s.startStream(streamID, params, time.Now())
+}
+
+// ResumeStream starts a stream that existed before a restart. Output left on
+// disk is kept and FFmpeg appends to the existing playlist after a
+// discontinuity.
+func (s *Streamer) ResumeStream(streamID string, params EncodingParams, createdAt time.Time) error {
+	return s.startStream(streamID, params, createdAt)
+}
+
+func (s *Streamer) startStream(streamID string, params EncodingParams, createdAt time.Time) error {
+	s.mu.Lock()
+	if _, exists := s.activeStreams[streamID]; exists {
+		s.mu.Unlock()
+		return fmt.Errorf("stream %s is already running", streamID)
+	}
+	process := &StreamProcess{
+		params:    params,
+		state:     StateStarting,
+		createdAt: createdAt,
+		stopChan:  make(chan struct{}),
+		stderr:    newLineRing(stderrLines),
+		parts:     newPartSignal(),
+		frames:    newFrameBroadcaster(),
+	}
+	if params.RTMP && !params.RTMPOnly {
+		process.rtmp = newRTMPPusher(params.RTMPTarget(streamID))
+	}
+	if params.Publish {
+		process.publish = &publishState{done: make(chan struct{})}
 	}
+	s.activeStreams[streamID] = process
+	metrics.ActiveStreams.Set(float64(len(s.activeStreams)))
+	s.mu.Unlock()
 
-	// Use the existing FIFO
-	fifoPath := stream.FIFOPath
+	process.setFrame(s.placeholderFrame(params))
 
-	// Open the FIFO for writing
-	fifo, err := os.OpenFile(fifoPath, os.O_WRONLY, os.ModeNamedPipe)
+	streamPath := s.StreamDir(streamID)
+	if err := os.MkdirAll(streamPath, 0755); err != nil {
+		s.forget(streamID, process)
+		return fmt.Errorf("failed to create stream directory %s: %v", streamPath, err)
+	}
+
+	// A playlist left behind by a previous run is appended to, so mark the
+	// point where the new encoder takes over.
+	_, err := os.Stat(filepath.Join(streamPath, params.outputPlaylist()))
+	resumed := err == nil
+	if s.inMemory(params) {
+		s.segments.Open(streamID)
+		_, resumed = s.segments.Get(streamID, params.outputPlaylist())
+	}
+	if resumed && params.prunable() && !s.inMemory(params) {
+		process.pruned.restore(streamPath)
+	}
+
+	// The DASH muxer writes its own master playlist, and RTMP-only streams
+	// write no playlist at all.
+	if len(params.Renditions) > 0 && !params.DASH && !params.RTMPOnly {
+		if err := writeMasterPlaylist(streamPath, params); err != nil {
+			s.forget(streamID, process)
+			return err
+		}
+	}
+
+	if err := s.startEncoder(streamID, process, resumed); err != nil {
+		s.forget(streamID, process)
+		return err
+	}
+
+	go s.runFrameClock(streamID, process, &process.fifo)
+	go s.superviseEncoder(streamID, process)
+	if process.rtmp != nil {
+		go s.runFrameClock(streamID+" (RTMP)", process, &process.rtmp.fifo)
+		go s.runRTMPPush(streamID, process)
+	}
+	if process.publish != nil {
+		go s.runPublisher(streamID, process)
+	}
+	return nil
+}
+
+// startEncoder creates the stream's FIFO and launches FFmpeg reading from
+// it, recording the run on the process.
+func (s *Streamer) startEncoder(streamID string, process *StreamProcess, discontinuity bool) error {
+	streamPath := s.StreamDir(streamID)
+	fifoPath, err := s.createFIFO(streamPath, inputFIFO)
 	if err != nil {
-		return fmt.Errorf("failed to open FIFO for writing: %v", err)
+		return err
 	}
-	defer fifo.Close()
-	streamPath := filepath.Join(s.outputPath, "stream", streamID)
 
-	// Start FFmpeg if not already running
-	if _, exists := s.activeStreams[streamID]; !exists {
-		cmd, fifoFile, err := s.startPersistentFFmpeg(fifoPath, streamID)
-		if err != nil {
-			log.Printf("Error starting FFmpeg for %s: %v", streamPath, err)
-			return fmt.Errorf("error starting FFmpeg for %s: %v", streamPath, err)
+	run, fifoFile, err := s.startPersistentFFmpeg(fifoPath, streamID, process, discontinuity)
+	if err != nil {
+		return fmt.Errorf("error starting FFmpeg for %s: %v", streamPath, err)
+	}
+
+	s.mu.Lock()
+	if s.activeStreams[streamID] != process {
+		// The stream was stopped while FFmpeg was starting up.
+		s.mu.Unlock()
+		fifoFile.Close()
+		run.cmd.Process.Kill()
+		<-run.done
+		return errStreamStopped
+	}
+	process.run = run
+	process.state = StateRunning
+	s.mu.Unlock()
+
+	process.fifo.open(fifoPath, fifoFile, run)
+
+	log.Printf("Started FFmpeg for stream %s with PID %d", streamPath, run.cmd.Process.Pid)
+	return nil
+}
+
+// forget removes a stream that failed to start from the active set.
+func (s *Streamer) forget(streamID string, process *StreamProcess) {
+	s.mu.Lock()
+	if s.activeStreams[streamID] == process {
+		delete(s.activeStreams, streamID)
+		metrics.ActiveStreams.Set(float64(len(s.activeStreams)))
+		if s.segments != nil {
+			s.segments.DeleteStream(streamID)
 		}
-		stopChan := make(chan struct{})
-		s.activeStreams[streamID] = &StreamProcess{
-			cmd:      cmd,
-			fifoFile: fifoFile,
-			stopChan: stopChan,
-			FIFOPath: fifoPath,
+	}
+	s.mu.Unlock()
+}
+
+// StopStream terminates the encoder of a stream and removes its output
+// directory, including the FIFO.
+func (s *Streamer) StopStream(streamID string) error {
+	s.mu.Lock()
+	process, exists := s.activeStreams[streamID]
+	delete(s.activeStreams, streamID)
+	metrics.ActiveStreams.Set(float64(len(s.activeStreams)))
+	s.mu.Unlock()
+
+	if exists {
+		s.stopProcess(streamID, process)
+		if process.publish != nil {
+			s.unpublish(streamID)
 		}
-		log.Printf("Started FFmpeg for stream %s with PID %d", streamPath, cmd.Process.Pid)
+	}
+	metrics.DeleteStream(streamID)
+	if s.segments != nil {
+		s.segments.DeleteStream(streamID)
+	}
+
+	if err := os.RemoveAll(s.StreamDir(streamID)); err != nil {
+		return fmt.Errorf("error removing output for stream %s: %v", streamID, err)
+	}
+	log.Printf("Stopped stream %s", streamID)
+	return nil
+}
+
+// stopProcess closes the FIFO and interrupts FFmpeg, killing it if it does
+// not exit within stopTimeout.
+func (s *Streamer) stopProcess(streamID string, process *StreamProcess) {
+	close(process.stopChan)
+	process.frames.close()
+	process.fifo.close()
+	if process.rtmp != nil {
+		process.rtmp.stop(streamID)
+	}
+	if process.publish != nil {
+		<-process.publish.done
+	}
+
+	s.mu.Lock()
+	run := process.run
+	process.state = StateExited
+	s.mu.Unlock()
+	if run == nil {
+		return
+	}
+
+	if err := run.cmd.Process.Signal(os.Interrupt); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		log.Printf("Error sending interrupt to FFmpeg for %s: %v", streamID, err)
+	}
+	select {
+	case <-run.done:
+	case <-time.After(stopTimeout):
+		log.Printf("FFmpeg for %s did not exit in time, killing it", streamID)
+		run.cmd.Process.Kill()
+		<-run.done
+	}
+}
 
-		// Start keepStreamAlive in a separate goroutine
-		go s.keepStreamAlive(streamPath, stopChan)
+// StreamInfo reports the state of a single stream.
+func (s *Streamer) StreamInfo(streamID string) (StreamInfo, error) {
+	s.mu.Lock()
+	process, exists := s.activeStreams[streamID]
+	if !exists {
+		s.mu.Unlock()
+		return StreamInfo{}, ErrStreamNotFound
+	}
+	info := StreamInfo{
+		ID:        streamID,
+		State:     process.state,
+		CreatedAt: process.createdAt,
+		Restarts:  process.restarts,
+		LastError: process.lastError,
+		Params:    process.params.Redacted(),
+	}
+	if !process.lastFrame.IsZero() {
+		lastFrame := process.lastFrame
+		info.LastFrameAt = &lastFrame
 	}
+	if !process.lastErrorAt.IsZero() {
+		lastErrorAt := process.lastErrorAt
+		info.LastErrorAt = &lastErrorAt
+	}
+	if process.run != nil && process.state == StateRunning {
+		info.PID = process.run.cmd.Process.Pid
+	}
+	if params := process.params; params.RTMPOnly {
+		// The stream's own encoder is the push.
+		info.RTMP = &RTMPStatus{
+			URL:         RedactRTMPURL(params.RTMPTarget(streamID)),
+			State:       info.State,
+			Restarts:    info.Restarts,
+			LastError:   info.LastError,
+			LastErrorAt: info.LastErrorAt,
+		}
+	}
+	s.mu.Unlock()
 
-	// Write the new image to the FIFO
-	streamProcess := s.activeStreams[streamPath]
-	if streamProcess == nil {
-		log.Printf("No active stream process found for %s after initialization", streamPath)
-		return fmt.Errorf("no active stream process found for %s after initialization", streamPath)
+	if process.rtmp != nil {
+		info.RTMP = process.rtmp.status()
+	}
+	if process.publish != nil {
+		info.Publish = s.publishStatus(streamID, process)
 	}
 
-	if err := s.writeImageToFIFO(streamProcess.fifoFile, imagePath, streamID); err != nil {
-		log.Printf("Error writing image to FIFO for StreamID %s: %v", streamID, err)
-		return fmt.Errorf("error writing image to FIFO for StreamID %s: %v", streamID, err)
+	if s.inMemory(info.Params) {
+		info.SegmentCount = s.segments.Segments(streamID)
 	} else {
-		log.Printf("Successfully wrote image to FIFO for StreamID %s", streamID)
+		info.SegmentCount = countSegments(s.StreamDir(streamID))
 	}
-	return nil
+	return info, nil
 }
 
-func (s *Streamer) keepStreamAlive(streamPath string, stopChan <-chan struct{}) {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
+// countSegments returns the number of media segments currently on disk,
+// across every rendition.
+func countSegments(streamPath string) int {
+	entries, err := os.ReadDir(streamPath)
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			count += countSegments(filepath.Join(streamPath, entry.Name()))
+		} else if strings.HasSuffix(entry.Name(), ".ts") || strings.HasSuffix(entry.Name(), ".m4s") {
+			count++
+		}
+	}
+	return count
+}
 
-	for {
-		select {
-		case <-stopChan:
-			log.Printf("Stopping keepStreamAlive for %s", streamPath)
-			return
-		case <-ticker.C:
-			s.mu.Lock()
-			if _, exists := s.activeStreams[streamPath]; exists {
-				// Check if m3u8 file exists
-				m3u8Path := filepath.Join(streamPath, "stream.m3u8")
-				if _, err := os.Stat(m3u8Path); os.IsNotExist(err) {
-					log.Printf("M3U8 file not found for %s, waiting...", streamPath)
-				} else {
-					log.Printf("M3U8 file exists for %s", streamPath)
-				}
-
-				// Write placeholder image only if no new image has been processed
-				// if err := s.writeImageToFIFO(process.fifoFile, s.placeholderImg, filepath.Base(streamPath)); err != nil {
-				// 	log.Printf("Error writing placeholder to FIFO for %s: %v", streamPath, err)
-				// }
-			} else {
-				s.mu.Unlock()
-				return
-			}
-			s.mu.Unlock()
+// ProcessImage replaces the frame held by a stream with an image on disk.
+func (s *Streamer) ProcessImage(streamID, imagePath string) error {
+	data, err := os.ReadFile(imagePath)
+	if err != nil {
+		return fmt.Errorf("error opening image file %s: %v", imagePath, err)
+	}
+	return s.ProcessImageData(streamID, data)
+}
+
+// ProcessImageData replaces the frame held by a stream with an in-memory
+// encoded image. The frame clock keeps repeating it until the next one
+// arrives.
+func (s *Streamer) ProcessImageData(streamID string, data []byte) error {
+	s.mu.Lock()
+	stream, exists := s.activeStreams[streamID]
+	s.mu.Unlock()
+	if !exists {
+		return fmt.Errorf("stream %s: %w", streamID, ErrStreamNotFound)
+	}
+
+	width, height, err := ParseResolution(stream.params.Resolution)
+	if err != nil {
+		return err
+	}
+	frame, err := encodeFrame(data, width, height)
+	if err != nil {
+		return fmt.Errorf("stream %s: %v", streamID, err)
+	}
+	stream.setFrame(frame)
+	metrics.ImagesProcessed.WithLabelValues(streamID).Inc()
+	if stream.params.Record && s.recorder != nil {
+		if err := s.recorder.Record(streamID, frame, time.Now()); err != nil {
+			log.Printf("Error recording frame for stream %s: %v", streamID, err)
 		}
 	}
+
+	s.mu.Lock()
+	stream.lastFrame = time.Now()
+	s.mu.Unlock()
+	log.Printf("Updated held frame for stream %s", streamID)
+	return nil
 }
 
 func (s *Streamer) Shutdown() {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	for streamPath, process := range s.activeStreams {
-		log.Printf("Shutting down stream: %s", streamPath)
-		close(process.stopChan)
-		if err := process.fifoFile.Close(); err != nil {
-			log.Printf("Error closing FIFO for %s: %v", streamPath, err)
-		}
-		if err := process.cmd.Process.Signal(os.Interrupt); err != nil {
-			log.Printf("Error sending interrupt to FFmpeg for %s: %v", streamPath, err)
+	processes := s.activeStreams
+	s.activeStreams = make(map[string]*StreamProcess)
+	metrics.ActiveStreams.Set(0)
+	s.mu.Unlock()
+
+	for streamID, process := range processes {
+		log.Printf("Shutting down stream: %s", streamID)
+		s.stopProcess(streamID, process)
+		if s.publisher != nil {
+			// The bucket copy stays, like the output on disk.
+			s.publisher.Forget(streamID)
 		}
 	}
 }
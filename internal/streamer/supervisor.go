@@ -0,0 +1,109 @@
+package streamer
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/abaddouh/poll-streamer/internal/metrics"
+)
+
+const (
+	// restartBackoffMin is the delay before the first restart of an encoder.
+	restartBackoffMin = 500 * time.Millisecond
+	// restartBackoffMax caps the delay between restarts.
+	restartBackoffMax = 30 * time.Second
+	// stableRunTime is how long an encoder has to stay up for its backoff to
+	// be reset.
+	stableRunTime = time.Minute
+)
+
+var (
+	// errStreamStopped is returned when a stream is stopped while its encoder
+	// is being (re)started.
+	errStreamStopped = errors.New("stream was stopped")
+	// errEncoderDown is returned when a frame is written while the encoder is
+	// restarting.
+	errEncoderDown = errors.New("encoder is not running")
+)
+
+// restartDelay returns the exponential backoff delay for the given attempt.
+func restartDelay(attempt int) time.Duration {
+	delay := restartBackoffMin
+	for i := 0; i < attempt && delay < restartBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > restartBackoffMax {
+		delay = restartBackoffMax
+	}
+	return delay
+}
+
+// superviseEncoder watches a stream's encoder and restarts it with
+// exponential backoff whenever it exits, until the stream is stopped. Each
+// restart recreates the FIFO and marks a discontinuity in the playlist.
+func (s *Streamer) superviseEncoder(streamID string, process *StreamProcess) {
+	attempt := 0
+	for {
+		s.mu.Lock()
+		run := process.run
+		s.mu.Unlock()
+
+		select {
+		case <-process.stopChan:
+			return
+		case <-run.done:
+		}
+
+		process.fifo.close()
+		reason := run.exitReason()
+		if time.Since(run.startedAt) >= stableRunTime {
+			attempt = 0
+		}
+
+		s.mu.Lock()
+		process.state = StateRestarting
+		process.lastError = reason
+		process.lastErrorAt = time.Now()
+		s.mu.Unlock()
+
+		for {
+			delay := restartDelay(attempt)
+			attempt++
+			log.Printf("FFmpeg for stream %s exited (%s), restarting in %v", streamID, reason, delay)
+
+			select {
+			case <-process.stopChan:
+				return
+			case <-time.After(delay):
+			}
+
+			// Start over with a fresh FIFO; the old one may still hold a
+			// partially consumed frame.
+			fifoPath := filepath.Join(s.StreamDir(streamID), inputFIFO)
+			if err := os.Remove(fifoPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Error removing FIFO %s: %v", fifoPath, err)
+			}
+
+			err := s.startEncoder(streamID, process, true)
+			if err == nil {
+				s.mu.Lock()
+				process.restarts++
+				s.mu.Unlock()
+				metrics.FFmpegRestarts.WithLabelValues(streamID).Inc()
+				break
+			}
+			if errors.Is(err, errStreamStopped) {
+				return
+			}
+
+			reason = err.Error()
+			s.mu.Lock()
+			process.lastError = reason
+			process.lastErrorAt = time.Now()
+			s.mu.Unlock()
+		}
+	}
+}
@@ -3,6 +3,7 @@ package watcher
 import (
 	"context"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
@@ -10,20 +11,67 @@ import (
 	"sync"
 	"time"
 
+	"github.com/abaddouh/poll-streamer/internal/metrics"
 	"github.com/fsnotify/fsnotify"
 )
 
+// WatcherJob is a single frame destined for a stream. Frames read from disk
+// carry a FilePath; frames received in memory, such as HTTP uploads, carry
+// their encoded bytes in Data instead.
 type WatcherJob struct {
 	FilePath string
 	StreamID string
+	Data     []byte
 }
 
 type Watcher struct {
 	watcher   *fsnotify.Watcher
 	imagePath string
+	// streamID is the stream every image belongs to, or "" if images are
+	// attributed to the stream named after their directory.
+	streamID string
+	// enqueueLatest enqueues the newest image of every stream directory
+	// when the watcher starts.
+	enqueueLatest bool
+	done          chan struct{}
+
+	mu          sync.Mutex
+	frames      int
+	lastFrameAt time.Time
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// Stats counts the images a watcher enqueued and reports its last error.
+type Stats struct {
+	Frames      int
+	LastFrameAt time.Time
+	LastError   string
+	LastErrorAt time.Time
+}
+
+// New creates a watcher over imagePath and every directory below it,
+// attributing images to the stream named after their directory. With
+// enqueueLatest, the newest image already in each directory is enqueued
+// when the watcher starts, so streams resume from their last frame after a
+// restart.
+func New(imagePath string, enqueueLatest bool) (*Watcher, error) {
+	w, err := newWatcher(imagePath, "")
+	if err != nil {
+		return nil, err
+	}
+	w.enqueueLatest = enqueueLatest
+	w.addSubdirs(imagePath)
+	return w, nil
 }
 
-func New(imagePath string) (*Watcher, error) {
+// NewStream creates a watcher that enqueues every image written to dir
+// for a single stream. Subdirectories of dir are ignored.
+func NewStream(dir, streamID string) (*Watcher, error) {
+	return newWatcher(dir, streamID)
+}
+
+func newWatcher(imagePath, streamID string) (*Watcher, error) {
 	fw, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create fsnotify watcher: %v", err)
@@ -39,29 +87,138 @@ func New(imagePath string) (*Watcher, error) {
 	return &Watcher{
 		watcher:   fw,
 		imagePath: imagePath,
+		streamID:  streamID,
+		done:      make(chan struct{}),
 	}, nil
 }
 
+// addSubdirs watches every directory below dir.
+func (w *Watcher) addSubdirs(dir string) {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Error scanning %s: %v", path, err)
+			return nil
+		}
+		if !d.IsDir() || path == dir {
+			return nil
+		}
+		if err := w.watcher.Add(path); err != nil {
+			log.Printf("Error adding directory %s to watcher: %v", path, err)
+			w.recordError(err)
+			return nil
+		}
+		log.Printf("Now watching existing directory: %s", path)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error scanning %s: %v", dir, err)
+	}
+}
+
+// enqueueLatestImages enqueues the newest image of every directory below
+// the image path.
+func (w *Watcher) enqueueLatestImages(ctx context.Context, jobs chan<- WatcherJob) {
+	filepath.WalkDir(w.imagePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() || path == w.imagePath {
+			return nil
+		}
+		latest := latestImage(path)
+		if latest == "" {
+			return nil
+		}
+		w.enqueue(ctx, jobs, latest)
+		return ctx.Err()
+	})
+}
+
+// latestImage returns the most recently modified image directly in dir,
+// or "" if it has none.
+func latestImage(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Error reading directory %s: %v", dir, err)
+		return ""
+	}
+	var (
+		latest   string
+		latestAt time.Time
+	)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !hasImageExt(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		// Names break ties, as images are usually named in sequence.
+		if latest == "" || info.ModTime().After(latestAt) ||
+			(info.ModTime().Equal(latestAt) && entry.Name() > filepath.Base(latest)) {
+			latest = filepath.Join(dir, entry.Name())
+			latestAt = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// Path returns the directory being watched.
+func (w *Watcher) Path() string {
+	return w.imagePath
+}
+
+// Done is closed once the watcher has stopped.
+func (w *Watcher) Done() <-chan struct{} {
+	return w.done
+}
+
+// Stats reports what the watcher has enqueued so far.
+func (w *Watcher) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return Stats{
+		Frames:      w.frames,
+		LastFrameAt: w.lastFrameAt,
+		LastError:   w.lastError,
+		LastErrorAt: w.lastErrorAt,
+	}
+}
+
+// recordError keeps err as the watcher's last error.
+func (w *Watcher) recordError(err error) {
+	w.mu.Lock()
+	w.lastError = err.Error()
+	w.lastErrorAt = time.Now()
+	w.mu.Unlock()
+}
+
 // isImageFile checks if the given filename has a valid image extension.
 func isImageFile(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	valid := false
-	switch ext {
-	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff":
-		valid = true
-	}
+	valid := hasImageExt(filename)
 	log.Printf("isImageFile: %s -> %v", filename, valid)
 	return valid
 }
 
+// hasImageExt reports whether filename has a valid image extension.
+func hasImageExt(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff":
+		return true
+	}
+	return false
+}
+
 func (w *Watcher) Start(ctx context.Context, jobs chan<- WatcherJob) {
 	var (
-		eventDebounce  = 100 * time.Millisecond
-		lastEventTimes = make(map[string]time.Time)
-		debounceMutex  sync.Mutex
+		eventDebounce = 100 * time.Millisecond
+		pending       = make(map[string]*time.Timer)
+		debounceMutex sync.Mutex
 	)
 
 	go func() {
+		defer close(w.done)
+		if w.enqueueLatest {
+			w.enqueueLatestImages(ctx, jobs)
+		}
 		for {
 			select {
 			case <-ctx.Done():
@@ -73,6 +230,11 @@ func (w *Watcher) Start(ctx context.Context, jobs chan<- WatcherJob) {
 					log.Println("Watcher events channel closed")
 					return
 				}
+				for _, op := range []fsnotify.Op{fsnotify.Create, fsnotify.Write, fsnotify.Remove, fsnotify.Rename, fsnotify.Chmod} {
+					if event.Has(op) {
+						metrics.WatcherEvents.WithLabelValues(strings.ToLower(op.String())).Inc()
+					}
+				}
 				if event.Op&(fsnotify.Create|fsnotify.Write) != 0 {
 					fi, err := os.Stat(event.Name)
 					if err != nil {
@@ -80,12 +242,18 @@ func (w *Watcher) Start(ctx context.Context, jobs chan<- WatcherJob) {
 						continue
 					}
 					if fi.IsDir() {
+						if w.streamID != "" {
+							continue
+						}
 						log.Printf("New directory detected: %s", event.Name)
 						// Add the new directory to the watcher
 						if err := w.watcher.Add(event.Name); err != nil {
 							log.Printf("Error adding new directory to watcher: %v", err)
+							w.recordError(err)
 						} else {
 							log.Printf("Now watching new directory: %s", event.Name)
+							// Directories created along with it may predate the watch.
+							w.addSubdirs(event.Name)
 						}
 						continue
 					}
@@ -95,27 +263,24 @@ func (w *Watcher) Start(ctx context.Context, jobs chan<- WatcherJob) {
 						continue
 					}
 
-					// Debounce logic
+					// Debounce logic: enqueue the file once it has been quiet
+					// for eventDebounce, so partially written images are skipped.
+					name := event.Name
 					debounceMutex.Lock()
-					lastTime, exists := lastEventTimes[event.Name]
-					now := time.Now()
-					if exists && now.Sub(lastTime) < eventDebounce {
+					if timer, exists := pending[name]; exists {
+						timer.Reset(eventDebounce)
 						debounceMutex.Unlock()
-						continue // Skip this event as it's too soon after the last one
+						continue
 					}
-					lastEventTimes[event.Name] = now
-					debounceMutex.Unlock()
-
-					log.Println("File created or modified:", event.Name)
-					streamID := filepath.Base(filepath.Dir(event.Name))
-					log.Printf("Extracted StreamID: %s from FilePath: %s", streamID, event.Name)
+					pending[name] = time.AfterFunc(eventDebounce, func() {
+						debounceMutex.Lock()
+						delete(pending, name)
+						debounceMutex.Unlock()
 
-					select {
-					case jobs <- WatcherJob{FilePath: event.Name, StreamID: streamID}:
-						log.Printf("Job enqueued: StreamID=%s, FilePath=%s", streamID, event.Name)
-					case <-ctx.Done():
-						return
-					}
+						log.Println("File created or modified:", name)
+						w.enqueue(ctx, jobs, name)
+					})
+					debounceMutex.Unlock()
 				}
 			case err, ok := <-w.watcher.Errors:
 				if !ok {
@@ -123,7 +288,28 @@ func (w *Watcher) Start(ctx context.Context, jobs chan<- WatcherJob) {
 					return
 				}
 				log.Println("Watcher Error:", err)
+				w.recordError(err)
 			}
 		}
 	}()
 }
+
+// enqueue places the image at name on jobs for its stream.
+func (w *Watcher) enqueue(ctx context.Context, jobs chan<- WatcherJob, name string) {
+	streamID := w.streamID
+	if streamID == "" {
+		streamID = filepath.Base(filepath.Dir(name))
+		log.Printf("Extracted StreamID: %s from FilePath: %s", streamID, name)
+	}
+
+	select {
+	case jobs <- WatcherJob{FilePath: name, StreamID: streamID}:
+		metrics.JobsEnqueued.WithLabelValues("watcher").Inc()
+		w.mu.Lock()
+		w.frames++
+		w.lastFrameAt = time.Now()
+		w.mu.Unlock()
+		log.Printf("Job enqueued: StreamID=%s, FilePath=%s", streamID, name)
+	case <-ctx.Done():
+	}
+}